@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+)
+
+const autocompleteUsage = `usage: %s autocomplete rebuild
+
+Rebuilds the Redis username index of AutocompleteUsers from Postgres.
+`
+
+// runAutocompleteCommand runs "autocomplete rebuild"
+func runAutocompleteCommand(index *storage.UserIndex, args []string) error {
+	if len(args) == 0 || args[0] != "rebuild" {
+		fmt.Fprintf(os.Stderr, autocompleteUsage, os.Args[0])
+		return fmt.Errorf("unknown autocomplete command")
+	}
+
+	count, err := index.Rebuild()
+	if err != nil {
+		return fmt.Errorf("failed to rebuild the index: %w", err)
+	}
+
+	fmt.Printf("Indexed %d users\n", count)
+	return nil
+}
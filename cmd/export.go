@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/userfile"
+	"github.com/ibrat-muslim/blog_app_user_service/service"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+)
+
+const exportUsage = `usage: %s export --file path [--format csv|jsonl|parquet] [filters]
+
+Exports the users matching the filters, "--file -" writes to the standard
+output. Passwords are never exported.
+`
+
+// runExportCommand runs "export"
+func runExportCommand(strg storage.StorageI, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, exportUsage, os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	path := flags.String("file", "", "output file, - for the standard output")
+	format := flags.String("format", "", "csv, jsonl or parquet, by the file extension by default")
+	search := flags.String("search", "", "text in the name, username, email or phone number")
+	types := flags.String("types", "", "comma separated user types")
+	roles := flags.String("roles", "", "comma separated roles")
+	gender := flags.String("gender", "", "male or female")
+	createdFrom := flags.String("created-from", "", "RFC3339 time, inclusive")
+	createdTo := flags.String("created-to", "", "RFC3339 time, exclusive")
+	verified := flags.String("verified", "", "true or false")
+	organizationID := flags.Int64("organization-id", 0, "members of the organization only")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		flags.Usage()
+		return fmt.Errorf("no file to export to")
+	}
+
+	if *format == "" {
+		if *path == "-" {
+			return fmt.Errorf("--format is required with --file -")
+		}
+
+		var err error
+		if *format, err = userfile.FormatOf(*path); err != nil {
+			return err
+		}
+	}
+
+	params := repo.GetUsersParams{
+		Search:         *search,
+		Types:          splitList(*types),
+		Roles:          splitList(*roles),
+		Gender:         *gender,
+		OrganizationID: *organizationID,
+	}
+
+	var err error
+
+	if *createdFrom != "" {
+		if params.CreatedFrom, err = time.Parse(time.RFC3339, *createdFrom); err != nil {
+			return fmt.Errorf("invalid --created-from: %w", err)
+		}
+	}
+
+	if *createdTo != "" {
+		if params.CreatedTo, err = time.Parse(time.RFC3339, *createdTo); err != nil {
+			return fmt.Errorf("invalid --created-to: %w", err)
+		}
+	}
+
+	if *verified != "" {
+		value, err := strconv.ParseBool(*verified)
+		if err != nil {
+			return fmt.Errorf("invalid --verified: %w", err)
+		}
+		params.Verified = &value
+	}
+
+	var out io.Writer = os.Stdout
+	if *path != "-" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+
+	count, err := service.ExportUsers(strg, &params, w, *format)
+	if err != nil {
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d users\n", count)
+	return nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+
+	return items
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/userfile"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/username"
+	"github.com/ibrat-muslim/blog_app_user_service/service"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+)
+
+const importUsage = `usage: %s import --file path [--format csv|jsonl] [--on-duplicate skip|update|replace] [--dry-run] [--batch-size n]
+
+Imports users from a CSV file with a header row or a JSONL file, the columns
+are first_name, last_name, email, phone_number, gender, username, password,
+password_hash and type. Users whose email is taken are skipped, updated
+keeping their password, or replaced including the password.
+`
+
+// runImportCommand runs "import", it prints the report of the rows
+func runImportCommand(strg storage.StorageI, usernames *username.Rules, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintf(os.Stderr, importUsage, os.Args[0]) }
+
+	path := flags.String("file", "", "CSV or JSONL file")
+	format := flags.String("format", "", "csv or jsonl, by the file extension by default")
+	onDuplicate := flags.String("on-duplicate", "skip", "skip, update or replace the users whose email is taken")
+	dryRun := flags.Bool("dry-run", false, "report the changes without writing them")
+	batchSize := flags.Int("batch-size", 0, "rows written in one transaction")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		flags.Usage()
+		return fmt.Errorf("no file to import")
+	}
+
+	if *format == "" {
+		var err error
+		if *format, err = userfile.FormatOf(*path); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := userfile.NewReader(file, *format)
+	if err != nil {
+		return err
+	}
+
+	userImport, err := service.NewUserImport(strg, usernames, service.UserImportOptions{
+		OnDuplicate: *onDuplicate,
+		DryRun:      *dryRun,
+		BatchSize:   *batchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", *path, err)
+		}
+
+		if err := userImport.Add(row); err != nil {
+			return fmt.Errorf("failed to import users: %w", err)
+		}
+	}
+
+	report, err := userImport.Finish()
+	if err != nil {
+		return fmt.Errorf("failed to import users: %w", err)
+	}
+
+	fmt.Print(report)
+	return nil
+}
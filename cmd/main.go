@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"os"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
@@ -12,12 +14,17 @@ import (
 	"google.golang.org/grpc/reflection"
 
 	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/authz"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/geoip"
 	grpcPkg "github.com/ibrat-muslim/blog_app_user_service/pkg/grpc_client"
 	"github.com/ibrat-muslim/blog_app_user_service/pkg/logger"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/ratelimit"
 
 	"github.com/ibrat-muslim/blog_app_user_service/config"
 	"github.com/ibrat-muslim/blog_app_user_service/service"
 	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
 )
 
 func main() {
@@ -43,26 +50,138 @@ func main() {
 	strg := storage.NewStoragePg(psqlConn)
 	inMemory := storage.NewInMemoryStorage(rdb)
 
+	logger := logger.New()
+
+	userIndex := storage.NewUserIndex(strg.User(), inMemory, logger)
+	strg = storage.WithUserIndex(strg, userIndex)
+
+	userCache := storage.NewUserCache(strg.User(), inMemory, cfg.UserCacheTTL, logger)
+	strg = storage.WithUserCache(strg, userCache)
+
+	// wrapped before the commands too, so their changes reach the running
+	// instances
+	permissionCache := storage.NewPermissionCache(strg.Permission(), inMemory, logger)
+	strg = storage.WithPermissionCache(strg, permissionCache)
+
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		if err := runPolicyCommand(&cfg, strg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	usernameRules, err := service.NewUsernameRules(&cfg)
+	if err != nil {
+		log.Fatalf("failed to configure usernames: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(strg, usernameRules, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExportCommand(strg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "autocomplete" {
+		if err := runAutocompleteCommand(userIndex, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if cfg.PolicyFile != "" && cfg.PolicySyncOnStart {
+		file, err := policy.LoadFile(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("failed to load policy file: %v", err)
+		}
+
+		diff, err := service.SyncPolicy(strg, file, false)
+		if err != nil {
+			log.Fatalf("failed to sync policy: %v", err)
+		}
+
+		if !diff.Empty() {
+			log.Printf("Policy synced:\n%s", diff)
+		}
+	}
+
 	grpcConn, err := grpcPkg.New(&cfg)
 	if err != nil {
 		log.Fatalf("failed to get grpc connections: %v", err)
 	}
 
-	logger := logger.New()
+	go permissionCache.Run(context.Background())
 
-	userService := service.NewUserService(strg, inMemory, logger)
-	authService := service.NewAuthService(strg, inMemory, grpcConn, &cfg, logger)
+	go service.RunUserPurge(context.Background(), strg, &cfg, logger)
+
+	// a fresh Redis has no username index
+	if built, err := userIndex.Built(); err != nil {
+		logger.WithError(err).Error("failed to check the username index")
+	} else if !built {
+		go func() {
+			if _, err := userIndex.Rebuild(); err != nil {
+				logger.WithError(err).Error("failed to build the username index")
+			}
+		}()
+	}
+
+	var geo *geoip.Reader
+	if cfg.GeoIPCityDBPath != "" {
+		geo, err = geoip.Open(cfg.GeoIPCityDBPath, cfg.GeoIPASNDBPath)
+		if err != nil {
+			log.Fatalf("failed to open geoip database: %v", err)
+		}
+		defer geo.Close()
+	}
+
+	captchaVerifiers, err := service.NewCaptchaVerifiers(&cfg, inMemory)
+	if err != nil {
+		log.Fatalf("failed to configure captcha: %v", err)
+	}
+
+	userService := service.NewUserService(strg, inMemory, userIndex, usernameRules, grpcConn, &cfg, logger)
+	authService := service.NewAuthService(strg, inMemory, grpcConn, geo, captchaVerifiers, &cfg, logger)
+
+	permissionService := service.NewPermissionService(strg, permissionCache, &cfg, logger)
+	roleService := service.NewRoleService(strg, &cfg, logger)
+	organizationService := service.NewOrganizationService(strg, grpcConn, &cfg, logger)
 
 	lis, err := net.Listen("tcp", cfg.GrpcPort)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	rateLimits, err := ratelimit.ParseLimits(cfg.RateLimits)
+	if err != nil {
+		log.Fatalf("failed to parse rate limits: %v", err)
+	}
+
+	permissionChecker := service.NewPermissionChecker(strg)
+
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			ratelimit.UnaryServerInterceptor(rateLimits, inMemory, &cfg, logger),
+			authz.UnaryServerInterceptor(service.UserServiceRules, permissionChecker, repo.UserTypeSuperAdmin, &cfg, logger),
+		),
+		grpc.ChainStreamInterceptor(
+			ratelimit.StreamServerInterceptor(rateLimits, inMemory, &cfg, logger),
+			authz.StreamServerInterceptor(service.UserServiceRules, permissionChecker, repo.UserTypeSuperAdmin, &cfg, logger),
+		),
+	)
 	reflection.Register(s)
 
 	pb.RegisterUserServiceServer(s, userService)
 	pb.RegisterAuthServiceServer(s, authService)
+	pb.RegisterPermissionServiceServer(s, permissionService)
+	pb.RegisterRoleServiceServer(s, roleService)
+	pb.RegisterOrganizationServiceServer(s, organizationService)
 
 	log.Println("Grpc server started in port", cfg.GrpcPort)
 
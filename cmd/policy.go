@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/service"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+)
+
+const policyUsage = `usage: %s policy sync [--dry-run] [--file path]
+
+Syncs the permissions table with the policy file, POLICY_FILE by default.
+`
+
+// runPolicyCommand runs "policy sync", it prints the changes made to the
+// permissions table, or the changes it would make with --dry-run
+func runPolicyCommand(cfg *config.Config, strg storage.StorageI, args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		fmt.Fprintf(os.Stderr, policyUsage, os.Args[0])
+		return fmt.Errorf("unknown policy command")
+	}
+
+	flags := flag.NewFlagSet("policy sync", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the changes without applying them")
+	path := flags.String("file", cfg.PolicyFile, "policy file, YAML or JSON")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *path == "" {
+		return fmt.Errorf("no policy file, set POLICY_FILE or pass --file")
+	}
+
+	file, err := policy.LoadFile(*path)
+	if err != nil {
+		return err
+	}
+
+	diff, err := service.SyncPolicy(strg, file, *dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to sync policy: %w", err)
+	}
+
+	fmt.Print(diff)
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -15,6 +16,50 @@ type Config struct {
 
 	NotificationServiceHost     string
 	NotificationServiceGrpcPort string
+
+	GeoIPCityDBPath      string
+	GeoIPASNDBPath       string
+	SuspiciousLoginScore int32
+
+	CaptchaRPCs      string
+	CaptchaVerifyURL string
+	CaptchaSecret    string
+	PoWDifficulty    int
+
+	RateLimits string
+
+	// TrustedProxies is the number of proxies in front of the service that
+	// append to x-forwarded-for, without them the peer address is the client
+	TrustedProxies int
+
+	// TokenPermissions is "version" to embed the permission set version in
+	// access tokens, "list" to embed the permissions too, empty for neither
+	TokenPermissions string
+
+	// PolicyFile is the default file of the policy sync command, it is
+	// also synced on startup if PolicySyncOnStart is set
+	PolicyFile        string
+	PolicySyncOnStart bool
+
+	// Deleted users are purged after UserPurgeAfter, UserPurgeMode
+	// is "delete" to remove them or "anonymize" to clear their data
+	UserPurgeAfter time.Duration
+	UserPurgeMode  string
+
+	// UserCacheTTL is how long BatchGetUsers serves a user from Redis
+	UserCacheTTL time.Duration
+
+	// OrganizationRoles is a comma separated list of the roles members can
+	// get in an organization, besides the owner role
+	OrganizationRoles string
+
+	// UsernameChars is a regexp character class body like "a-z0-9_",
+	// UsernameReserved a comma separated list added to the built-in one
+	UsernameChars          string
+	UsernameMinLength      int
+	UsernameMaxLength      int
+	UsernameReserved       string
+	UsernameChangeCooldown time.Duration
 }
 
 type PostgresConfig struct {
@@ -53,6 +98,67 @@ func Load(path string) Config {
 		AuthSecretKey:               conf.GetString("AUTH_SECRET_KEY"),
 		NotificationServiceHost:     conf.GetString("NOTIFICATION_SERVICE_HOST"),
 		NotificationServiceGrpcPort: conf.GetString("NOTIFICATION_SERVICE_GRPC_PORT"),
+		GeoIPCityDBPath:             conf.GetString("GEOIP_CITY_DB_PATH"),
+		GeoIPASNDBPath:              conf.GetString("GEOIP_ASN_DB_PATH"),
+		SuspiciousLoginScore:        conf.GetInt32("SUSPICIOUS_LOGIN_SCORE"),
+		CaptchaRPCs:                 conf.GetString("CAPTCHA_RPCS"),
+		CaptchaVerifyURL:            conf.GetString("CAPTCHA_VERIFY_URL"),
+		CaptchaSecret:               conf.GetString("CAPTCHA_SECRET"),
+		PoWDifficulty:               conf.GetInt("POW_DIFFICULTY"),
+		RateLimits:                  conf.GetString("RATE_LIMITS"),
+		TrustedProxies:              conf.GetInt("TRUSTED_PROXIES"),
+		TokenPermissions:            conf.GetString("TOKEN_PERMISSIONS"),
+		PolicyFile:                  conf.GetString("POLICY_FILE"),
+		PolicySyncOnStart:           conf.GetBool("POLICY_SYNC_ON_START"),
+		UserPurgeAfter:              conf.GetDuration("USER_PURGE_AFTER"),
+		UserPurgeMode:               conf.GetString("USER_PURGE_MODE"),
+		UserCacheTTL:                conf.GetDuration("USER_CACHE_TTL"),
+		OrganizationRoles:           conf.GetString("ORGANIZATION_ROLES"),
+		UsernameChars:               conf.GetString("USERNAME_CHARS"),
+		UsernameMinLength:           conf.GetInt("USERNAME_MIN_LENGTH"),
+		UsernameMaxLength:           conf.GetInt("USERNAME_MAX_LENGTH"),
+		UsernameReserved:            conf.GetString("USERNAME_RESERVED"),
+		UsernameChangeCooldown:      conf.GetDuration("USERNAME_CHANGE_COOLDOWN"),
+	}
+
+	if cfg.SuspiciousLoginScore == 0 {
+		cfg.SuspiciousLoginScore = 50
+	}
+
+	if cfg.PoWDifficulty == 0 {
+		cfg.PoWDifficulty = 20
+	}
+
+	if cfg.UserPurgeAfter == 0 {
+		cfg.UserPurgeAfter = 30 * 24 * time.Hour
+	}
+
+	if cfg.OrganizationRoles == "" {
+		cfg.OrganizationRoles = "member"
+	}
+
+	if cfg.UserPurgeMode == "" {
+		cfg.UserPurgeMode = "anonymize"
+	}
+
+	if cfg.UserCacheTTL == 0 {
+		cfg.UserCacheTTL = 5 * time.Minute
+	}
+
+	if cfg.UsernameChars == "" {
+		cfg.UsernameChars = "a-zA-Z0-9_."
+	}
+
+	if cfg.UsernameMinLength == 0 {
+		cfg.UsernameMinLength = 3
+	}
+
+	if cfg.UsernameMaxLength == 0 {
+		cfg.UsernameMaxLength = 30
+	}
+
+	if cfg.UsernameChangeCooldown == 0 {
+		cfg.UsernameChangeCooldown = 30 * 24 * time.Hour
 	}
 
 	return cfg
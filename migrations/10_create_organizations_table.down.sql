@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
+
+DELETE FROM roles WHERE name = 'owner';
@@ -0,0 +1,6 @@
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS anonymized;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT false;
+
+-- users registered before the column existed confirmed their email in Verify
+UPDATE users SET verified = true;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users(created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_created_at_id_idx;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users(created_at);
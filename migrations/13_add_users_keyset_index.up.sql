@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_created_at_idx;
+
+-- serves the keyset pagination of the users, newest first
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users(created_at DESC, id DESC) WHERE deleted_at IS NULL;
@@ -0,0 +1,19 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- full-text search, names weigh more than the username and the email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', first_name || ' ' || last_name), 'A') ||
+    setweight(to_tsvector('simple', coalesce(username, '')), 'B') ||
+    setweight(to_tsvector('simple', email), 'C')
+) STORED;
+
+-- substring and fuzzy search through the trigram index
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_text TEXT GENERATED ALWAYS AS (
+    lower(
+        first_name || ' ' || last_name || ' ' || coalesce(username, '') || ' ' ||
+        email || ' ' || coalesce(phone_number, '')
+    )
+) STORED;
+
+CREATE INDEX IF NOT EXISTS users_search_vector_idx ON users USING GIN(search_vector);
+CREATE INDEX IF NOT EXISTS users_search_text_idx ON users USING GIN(search_text gin_trgm_ops);
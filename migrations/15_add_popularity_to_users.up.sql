@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS popularity BIGINT NOT NULL DEFAULT 0;
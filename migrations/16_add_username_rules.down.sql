@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_username_lower_key;
+
+ALTER TABLE users DROP COLUMN IF EXISTS username_changed_at;
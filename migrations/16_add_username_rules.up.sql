@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_changed_at TIMESTAMP WITH TIME ZONE;
+
+-- usernames equal but for case keep the oldest owner, the others get their id appended
+UPDATE users u SET username = left(u.username, 29 - length(u.id::TEXT)) || '_' || u.id
+WHERE EXISTS (
+    SELECT 1 FROM users o
+    WHERE lower(o.username) = lower(u.username) AND o.id < u.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_key ON users(lower(username)) WHERE username IS NOT NULL;
@@ -0,0 +1,5 @@
+DELETE FROM login_events WHERE type = 'token_refresh';
+
+ALTER TABLE login_events DROP CONSTRAINT IF EXISTS login_events_type_check;
+
+ALTER TABLE login_events ADD CONSTRAINT login_events_type_check CHECK (type IN('login', 'password_reset'));
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS organization_members_version_bump ON organization_members;
+DROP TRIGGER IF EXISTS user_roles_version_bump ON user_roles;
+DROP FUNCTION IF EXISTS bump_user_roles_version();
+DROP TABLE IF EXISTS user_roles_version;
@@ -0,0 +1,31 @@
+-- version counts the changes of the roles and memberships of the user, tokens
+-- carry it and are rejected once it moves. There is no foreign key because
+-- the triggers also run while users are deleted.
+CREATE TABLE IF NOT EXISTS user_roles_version (
+    user_id INTEGER PRIMARY KEY,
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE OR REPLACE FUNCTION bump_user_roles_version() RETURNS TRIGGER AS $$
+DECLARE
+    changed_user_id INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_user_id := OLD.user_id;
+    ELSE
+        changed_user_id := NEW.user_id;
+    END IF;
+
+    INSERT INTO user_roles_version (user_id, version) VALUES (changed_user_id, 1)
+    ON CONFLICT (user_id) DO UPDATE SET version = user_roles_version.version + 1;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_version_bump
+    AFTER INSERT OR UPDATE OR DELETE ON user_roles
+    FOR EACH ROW EXECUTE FUNCTION bump_user_roles_version();
+
+CREATE TRIGGER organization_members_version_bump
+    AFTER INSERT OR UPDATE OR DELETE ON organization_members
+    FOR EACH ROW EXECUTE FUNCTION bump_user_roles_version();
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_deleted_version_bump ON users;
+DROP FUNCTION IF EXISTS bump_user_deleted_version();
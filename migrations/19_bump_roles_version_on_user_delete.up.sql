@@ -0,0 +1,14 @@
+-- deleting or restoring a user also moves the version, so the tokens of a
+-- deleted user stop working
+CREATE OR REPLACE FUNCTION bump_user_deleted_version() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO user_roles_version (user_id, version) VALUES (NEW.id, 1)
+    ON CONFLICT (user_id) DO UPDATE SET version = user_roles_version.version + 1;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_deleted_version_bump
+    AFTER UPDATE OF deleted_at ON users
+    FOR EACH ROW WHEN (OLD.deleted_at IS DISTINCT FROM NEW.deleted_at)
+    EXECUTE FUNCTION bump_user_deleted_version();
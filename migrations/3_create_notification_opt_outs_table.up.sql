@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS notification_opt_outs (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR NOT NULL,
+    PRIMARY KEY(user_id, type)
+);
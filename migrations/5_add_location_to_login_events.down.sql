@@ -0,0 +1,6 @@
+ALTER TABLE login_events
+    DROP COLUMN IF EXISTS country,
+    DROP COLUMN IF EXISTS asn,
+    DROP COLUMN IF EXISTS latitude,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS risk_score;
@@ -0,0 +1,6 @@
+ALTER TABLE login_events
+    ADD COLUMN IF NOT EXISTS country VARCHAR(2),
+    ADD COLUMN IF NOT EXISTS asn BIGINT,
+    ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS risk_score SMALLINT NOT NULL DEFAULT 0;
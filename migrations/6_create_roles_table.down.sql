@@ -0,0 +1,11 @@
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_type_fkey;
+
+DELETE FROM permissions WHERE user_type NOT IN('superadmin', 'user');
+UPDATE users SET type = 'user' WHERE type NOT IN('superadmin', 'user');
+
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_check CHECK (user_type IN('superadmin', 'user'));
+ALTER TABLE users ADD CONSTRAINT users_type_check CHECK (type IN('superadmin', 'user'));
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description VARCHAR,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO roles(name, description) VALUES ('superadmin', 'Full access to every resource');
+INSERT INTO roles(name, description) VALUES ('user', 'Default role of registered users');
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY(user_id, role_id)
+);
+
+INSERT INTO user_roles(user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = u.type;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_type_check;
+ALTER TABLE users ADD CONSTRAINT users_type_fkey
+    FOREIGN KEY (type) REFERENCES roles(name) ON UPDATE CASCADE;
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_check;
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_fkey
+    FOREIGN KEY (user_type) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE condition != '';
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_resource_action_condition_key;
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_resource_action_key
+    UNIQUE(user_type, resource, action);
+
+ALTER TABLE permissions DROP COLUMN IF EXISTS condition;
@@ -0,0 +1,5 @@
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS condition VARCHAR NOT NULL DEFAULT '';
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_resource_action_key;
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_resource_action_condition_key
+    UNIQUE(user_type, resource, action, condition);
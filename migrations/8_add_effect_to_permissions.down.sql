@@ -0,0 +1,14 @@
+DELETE FROM permissions WHERE effect = 'deny' OR resource LIKE '%*%' OR action = '*';
+
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'users', 'create') ON CONFLICT DO NOTHING;
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'users', 'update') ON CONFLICT DO NOTHING;
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'users', 'delete') ON CONFLICT DO NOTHING;
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'categories', 'create') ON CONFLICT DO NOTHING;
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'posts', 'create') ON CONFLICT DO NOTHING;
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', 'posts', 'update') ON CONFLICT DO NOTHING;
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_resource_action_condition_effect_key;
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_resource_action_condition_key
+    UNIQUE(user_type, resource, action, condition);
+
+ALTER TABLE permissions DROP COLUMN IF EXISTS effect;
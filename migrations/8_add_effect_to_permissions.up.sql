@@ -0,0 +1,10 @@
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS effect VARCHAR NOT NULL DEFAULT 'allow'
+    CHECK (effect IN('allow', 'deny'));
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_user_type_resource_action_condition_key;
+ALTER TABLE permissions ADD CONSTRAINT permissions_user_type_resource_action_condition_effect_key
+    UNIQUE(user_type, resource, action, condition, effect);
+
+-- a single wildcard grant replaces the superadmin seed
+DELETE FROM permissions WHERE user_type = 'superadmin';
+INSERT INTO permissions(user_type, resource, action) VALUES ('superadmin', '*', '*');
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS permissions_version_bump ON permissions;
+DROP FUNCTION IF EXISTS bump_permission_version();
+DROP TABLE IF EXISTS permission_version;
@@ -0,0 +1,160 @@
+package authz
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rule is the permission a method requires
+type Rule struct {
+	Resource string
+	Action   string
+	// Target returns the user a per-user method acts on. Such methods are
+	// allowed only on the caller's own user unless the caller is an admin.
+	Target func(req interface{}) int64
+	// Admin methods act on many users at once and are allowed only to the
+	// admin role, on top of the permission
+	Admin bool
+}
+
+// PermissionChecker checks the permission against the permissions table
+type PermissionChecker interface {
+	// CheckToken rejects tokens issued before the roles of the user or the
+	// permissions changed, with a status error
+	CheckToken(payload *utils.Payload) error
+	CheckPermission(payload *utils.Payload, resource, action string, attributes map[string]string) (bool, error)
+}
+
+type payloadKey struct{}
+
+// ContextWithPayload returns a copy of ctx carrying the token payload
+func ContextWithPayload(ctx context.Context, payload *utils.Payload) context.Context {
+	return context.WithValue(ctx, payloadKey{}, payload)
+}
+
+// PayloadFromContext returns the payload of the verified token of the request
+func PayloadFromContext(ctx context.Context) (*utils.Payload, bool) {
+	payload, ok := ctx.Value(payloadKey{}).(*utils.Payload)
+	return payload, ok
+}
+
+// ID is the Target of requests naming the user by their id field
+func ID(req interface{}) int64 {
+	if r, ok := req.(interface{ GetId() int64 }); ok {
+		return r.GetId()
+	}
+	return 0
+}
+
+// UserID is the Target of requests naming the user by their user_id field
+func UserID(req interface{}) int64 {
+	if r, ok := req.(interface{ GetUserId() int64 }); ok {
+		return r.GetUserId()
+	}
+	return 0
+}
+
+// UnaryServerInterceptor verifies the bearer token of every request that has
+// one and puts its payload into the context. Methods listed in rules require
+// a token whose roles have the permission, the target user is passed to the
+// permission conditions as the "id" attribute.
+func UnaryServerInterceptor(rules map[string]Rule, checker PermissionChecker, adminRole string, cfg *config.Config, logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, info.FullMethod, req, rules, checker, adminRole, cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming methods.
+// The check runs before any message is received, so rules of streaming
+// methods can not have a Target.
+func StreamServerInterceptor(rules map[string]Rule, checker PermissionChecker, adminRole string, cfg *config.Config, logger *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), info.FullMethod, nil, rules, checker, adminRole, cfg, logger)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize checks the request of the method against its rule and returns
+// the context carrying the token payload
+func authorize(ctx context.Context, method string, req interface{}, rules map[string]Rule, checker PermissionChecker, adminRole string, cfg *config.Config, logger *logrus.Logger) (context.Context, error) {
+	rule, protected := rules[method]
+
+	token := utils.BearerToken(ctx)
+	if token == "" {
+		if protected {
+			return nil, status.Error(codes.Unauthenticated, "missing access token")
+		}
+		return ctx, nil
+	}
+
+	payload, err := utils.VerifyToken(cfg, token)
+	if err != nil {
+		if protected {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		}
+		return ctx, nil
+	}
+
+	if err := checker.CheckToken(payload); err != nil {
+		if protected {
+			return nil, err
+		}
+		return ctx, nil
+	}
+
+	ctx = ContextWithPayload(ctx, payload)
+
+	if !protected {
+		return ctx, nil
+	}
+
+	if rule.Admin && !payload.HasRole(adminRole) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	var attributes map[string]string
+	if rule.Target != nil {
+		target := rule.Target(req)
+		if target != payload.UserID && !payload.HasRole(adminRole) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		attributes = map[string]string{"id": strconv.FormatInt(target, 10)}
+	}
+
+	hasPermission, err := checker.CheckPermission(payload, rule.Resource, rule.Action, attributes)
+	if err != nil {
+		logger.WithError(err).Error("failed to check permission")
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	if !hasPermission {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return ctx, nil
+}
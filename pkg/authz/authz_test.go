@@ -0,0 +1,176 @@
+package authz
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeChecker struct {
+	permissions map[string]bool
+	attributes  map[string]string
+	stale       map[int64]bool
+}
+
+func (f *fakeChecker) CheckToken(payload *utils.Payload) error {
+	if f.stale[payload.UserID] {
+		return status.Error(codes.Unauthenticated, "stale_permissions")
+	}
+	return nil
+}
+
+func (f *fakeChecker) CheckPermission(payload *utils.Payload, resource, action string, attributes map[string]string) (bool, error) {
+	f.attributes = attributes
+	for _, role := range payload.Roles {
+		if f.permissions[role+":"+resource+":"+action] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type deleteRequest struct {
+	id int64
+}
+
+func (r *deleteRequest) GetId() int64 {
+	return r.id
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{AuthSecretKey: "secret"}
+	checker := &fakeChecker{permissions: map[string]bool{
+		"user:users:delete":       true,
+		"superadmin:users:delete": true,
+	}}
+
+	interceptor := UnaryServerInterceptor(
+		map[string]Rule{"/genproto.UserService/Delete": {Resource: "users", Action: "delete", Target: ID}},
+		checker,
+		"superadmin",
+		cfg,
+		logger,
+	)
+
+	withToken := func(userID int64, roles ...string) context.Context {
+		token, _, err := utils.CreateToken(cfg, &utils.TokenParams{
+			UserID:   userID,
+			Roles:    roles,
+			Duration: time.Minute,
+		})
+		require.NoError(t, err)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	var handlerPayload *utils.Payload
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerPayload, _ = PayloadFromContext(ctx)
+		return "ok", nil
+	}
+	deleteInfo := &grpc.UnaryServerInfo{FullMethod: "/genproto.UserService/Delete"}
+
+	_, err := interceptor(context.Background(), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// self
+	_, err = interceptor(withToken(1, "user"), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), handlerPayload.UserID)
+	require.Equal(t, map[string]string{"id": "1"}, checker.attributes)
+
+	// another user
+	_, err = interceptor(withToken(2, "user"), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// admin
+	_, err = interceptor(withToken(2, "superadmin"), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.NoError(t, err)
+
+	// self without the permission
+	_, err = interceptor(withToken(1, "guest"), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// unprotected methods get the payload when there is a token
+	handlerPayload = nil
+	_, err = interceptor(withToken(3, "user"), nil, &grpc.UnaryServerInfo{FullMethod: "/genproto.UserService/Get"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), handlerPayload.UserID)
+
+	// stale tokens are rejected and not passed on
+	checker.stale = map[int64]bool{1: true, 3: true}
+	_, err = interceptor(withToken(1, "user"), &deleteRequest{id: 1}, deleteInfo, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	handlerPayload = nil
+	_, err = interceptor(withToken(3, "user"), nil, &grpc.UnaryServerInfo{FullMethod: "/genproto.UserService/Get"}, handler)
+	require.NoError(t, err)
+	require.Nil(t, handlerPayload)
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{AuthSecretKey: "secret"}
+	checker := &fakeChecker{permissions: map[string]bool{
+		"superadmin:users:import": true,
+		"editor:users:import":     true,
+	}}
+
+	interceptor := StreamServerInterceptor(
+		map[string]Rule{"/genproto.UserService/ImportUsers": {Resource: "users", Action: "import", Admin: true}},
+		checker,
+		"superadmin",
+		cfg,
+		logger,
+	)
+
+	withToken := func(roles ...string) context.Context {
+		token, _, err := utils.CreateToken(cfg, &utils.TokenParams{UserID: 1, Roles: roles, Duration: time.Minute})
+		require.NoError(t, err)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	var handlerPayload *utils.Payload
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		handlerPayload, _ = PayloadFromContext(ss.Context())
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/genproto.UserService/ImportUsers", IsClientStream: true}
+
+	err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, info, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = interceptor(nil, &fakeServerStream{ctx: withToken("user")}, info, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the permission alone is not enough for admin methods
+	err = interceptor(nil, &fakeServerStream{ctx: withToken("editor")}, info, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	err = interceptor(nil, &fakeServerStream{ctx: withToken("superadmin")}, info, handler)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), handlerPayload.UserID)
+}
@@ -0,0 +1,66 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	KindHTTP = "http"
+	KindPoW  = "pow"
+)
+
+var ErrVerificationFailed = errors.New("captcha verification failed")
+
+// Verifier checks the token a client solved before calling a public RPC
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// Store keeps issued proof of work challenges until they are used
+type Store interface {
+	Set(key, value string, exp time.Duration) error
+	// Take returns the value of the key and deletes it in one step,
+	// so of concurrent callers only one gets the value
+	Take(key string) (string, error)
+}
+
+// ParseRPCs parses a comma separated list of rpc:kind pairs,
+// e.g. "Register:pow,Login:http", into a map of rpc name to verifier kind.
+// Every rpc has to be one of known, a mistyped name would leave the rpc
+// without a captcha.
+func ParseRPCs(s string, known []string) (map[string]string, error) {
+	rpcs := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		rpc, kind, ok := strings.Cut(pair, ":")
+		if !ok || (kind != KindHTTP && kind != KindPoW) {
+			return nil, fmt.Errorf("invalid captcha rpc %q", pair)
+		}
+
+		if !contains(known, rpc) {
+			return nil, fmt.Errorf("unknown captcha rpc %q, it can be one of %s", rpc, strings.Join(known, ", "))
+		}
+
+		rpcs[rpc] = kind
+	}
+
+	return rpcs, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore map[string]string
+
+func (m memoryStore) Set(key, value string, exp time.Duration) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryStore) Take(key string) (string, error) {
+	value, ok := m[key]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	delete(m, key)
+	return value, nil
+}
+
+func TestParseRPCs(t *testing.T) {
+	known := []string{"Register", "Login"}
+
+	rpcs, err := ParseRPCs("Register:pow, Login:http,", known)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Register": KindPoW, "Login": KindHTTP}, rpcs)
+
+	_, err = ParseRPCs("Register:recaptcha", known)
+	require.Error(t, err)
+
+	_, err = ParseRPCs("Regster:pow", known)
+	require.Error(t, err)
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "secret", r.PostForm.Get("secret"))
+
+		if r.PostForm.Get("response") == "valid" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	verifier := NewHTTPVerifier(server.URL, "secret")
+
+	require.NoError(t, verifier.Verify(context.Background(), "valid", "127.0.0.1"))
+	require.ErrorIs(t, verifier.Verify(context.Background(), "invalid", "127.0.0.1"), ErrVerificationFailed)
+	require.ErrorIs(t, verifier.Verify(context.Background(), "", "127.0.0.1"), ErrVerificationFailed)
+}
+
+func TestPoWVerifier(t *testing.T) {
+	verifier := NewPoWVerifier(memoryStore{}, 8, time.Minute)
+
+	challenge, difficulty, _, err := verifier.Challenge()
+	require.NoError(t, err)
+	require.Equal(t, 8, difficulty)
+
+	token := Solve(challenge, difficulty)
+	require.NoError(t, verifier.Verify(context.Background(), token, ""))
+
+	// a challenge can only be used once
+	require.ErrorIs(t, verifier.Verify(context.Background(), token, ""), ErrVerificationFailed)
+
+	require.ErrorIs(t, verifier.Verify(context.Background(), "unknown:1", ""), ErrVerificationFailed)
+}
@@ -0,0 +1,98 @@
+package captcha
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const powChallengeKey = "pow_challenge_"
+
+// PoWVerifier is a hashcash style proof of work. The client gets a challenge
+// and must find a counter such that sha256("challenge:counter") starts with
+// difficulty zero bits, then sends "challenge:counter" as the captcha token.
+// Each challenge can be used once.
+type PoWVerifier struct {
+	store      Store
+	difficulty int
+	ttl        time.Duration
+}
+
+func NewPoWVerifier(store Store, difficulty int, ttl time.Duration) *PoWVerifier {
+	return &PoWVerifier{
+		store:      store,
+		difficulty: difficulty,
+		ttl:        ttl,
+	}
+}
+
+// Challenge issues a new challenge and returns it with its expiration time
+func (v *PoWVerifier) Challenge() (string, int, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, time.Time{}, err
+	}
+
+	challenge := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(v.ttl)
+
+	err := v.store.Set(powChallengeKey+challenge, strconv.Itoa(v.difficulty), v.ttl)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+
+	return challenge, v.difficulty, expiresAt, nil
+}
+
+func (v *PoWVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	i := strings.LastIndex(token, ":")
+	if i < 0 {
+		return ErrVerificationFailed
+	}
+	challenge := token[:i]
+
+	value, err := v.store.Take(powChallengeKey + challenge)
+	if err != nil {
+		return ErrVerificationFailed
+	}
+
+	difficulty, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+
+	if leadingZeroBits(token) < difficulty {
+		return ErrVerificationFailed
+	}
+
+	return nil
+}
+
+// Solve finds the token for the challenge, it is what clients are expected to do
+func Solve(challenge string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		token := challenge + ":" + strconv.Itoa(counter)
+		if leadingZeroBits(token) >= difficulty {
+			return token
+		}
+	}
+}
+
+func leadingZeroBits(token string) int {
+	hash := sha256.Sum256([]byte(token))
+
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+
+	return n
+}
@@ -0,0 +1,183 @@
+package geoip
+
+import (
+	"math"
+	"net"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	newCountryScore       = 50
+	newASNScore           = 20
+	impossibleTravelScore = 60
+
+	// maxTravelSpeed is the speed in km/h above which the distance between
+	// two logins cannot have been covered, roughly an airliner's cruise speed.
+	maxTravelSpeed = 900
+	// minTravelDistance hides the inaccuracy of IP geolocation,
+	// addresses of the same city are often placed hundreds of kilometers apart.
+	minTravelDistance = 500
+	earthRadius       = 6371
+)
+
+type Location struct {
+	Country   string
+	ASN       int64
+	Latitude  float64
+	Longitude float64
+}
+
+// Login is a previous successful login of the user
+type Login struct {
+	Location  Location
+	CreatedAt time.Time
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number int64 `maxminddb:"autonomous_system_number"`
+}
+
+// Reader looks up IP addresses in MaxMind-format city and ASN databases
+type Reader struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens the city database and, if asnPath is not empty, the ASN database
+func Open(cityPath, asnPath string) (*Reader, error) {
+	city, err := maxminddb.Open(cityPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{city: city}
+
+	if asnPath != "" {
+		r.asn, err = maxminddb.Open(asnPath)
+		if err != nil {
+			city.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Lookup returns the location of the ip address.
+// Unknown and private addresses return an empty location.
+func (r *Reader) Lookup(ip string) (Location, error) {
+	var location Location
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return location, nil
+	}
+
+	var city cityRecord
+	if err := r.city.Lookup(addr, &city); err != nil {
+		return location, err
+	}
+
+	location.Country = city.Country.ISOCode
+	location.Latitude = city.Location.Latitude
+	location.Longitude = city.Location.Longitude
+
+	if r.asn != nil {
+		var asn asnRecord
+		if err := r.asn.Lookup(addr, &asn); err != nil {
+			return location, err
+		}
+		location.ASN = asn.Number
+	}
+
+	return location, nil
+}
+
+func (r *Reader) Close() error {
+	if r.asn != nil {
+		r.asn.Close()
+	}
+	return r.city.Close()
+}
+
+// RiskScore rates a login from the location at the given time against the
+// user's previous logins, most recent first. A login from a country or network
+// never seen before, or one too far from the previous login to be reachable
+// in the elapsed time, raises the score. Logins without a location, from
+// before GeoIP was enabled or from private addresses, are not compared, so
+// the first located login of a user scores 0.
+func RiskScore(location Location, at time.Time, history []Login) int32 {
+	if location.Country == "" {
+		return 0
+	}
+
+	var (
+		score                  int32
+		located                []Login
+		knownCountry, knownASN bool
+	)
+
+	for _, login := range history {
+		if login.Location.Country == "" {
+			continue
+		}
+		located = append(located, login)
+
+		if login.Location.Country == location.Country {
+			knownCountry = true
+		}
+		if login.Location.ASN == location.ASN {
+			knownASN = true
+		}
+	}
+
+	if len(located) == 0 {
+		return 0
+	}
+
+	if !knownCountry {
+		score += newCountryScore
+	}
+
+	if location.ASN != 0 && !knownASN {
+		score += newASNScore
+	}
+
+	previous := located[0]
+	distance := Distance(previous.Location, location)
+	hours := at.Sub(previous.CreatedAt).Hours()
+	if distance > minTravelDistance && (hours <= 0 || distance/hours > maxTravelSpeed) {
+		score += impossibleTravelScore
+	}
+
+	if score > 100 {
+		score = 100
+	}
+
+	return score
+}
+
+// Distance returns the great-circle distance between two locations in kilometers
+func Distance(a, b Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
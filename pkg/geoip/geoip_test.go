@@ -0,0 +1,40 @@
+package geoip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tashkent  = Location{Country: "UZ", ASN: 8193, Latitude: 41.2995, Longitude: 69.2401}
+	samarkand = Location{Country: "UZ", ASN: 8193, Latitude: 39.6270, Longitude: 66.9750}
+	newYork   = Location{Country: "US", ASN: 7922, Latitude: 40.7128, Longitude: -74.0060}
+)
+
+func TestDistance(t *testing.T) {
+	require.InDelta(t, 10200, Distance(tashkent, newYork), 100)
+	require.Zero(t, Distance(tashkent, tashkent))
+}
+
+func TestRiskScore(t *testing.T) {
+	now := time.Now()
+
+	require.Zero(t, RiskScore(tashkent, now, nil))
+
+	history := []Login{{Location: tashkent, CreatedAt: now.Add(-time.Hour)}}
+
+	require.Zero(t, RiskScore(samarkand, now, history))
+	require.Equal(t, int32(100), RiskScore(newYork, now, history))
+
+	history[0].CreatedAt = now.Add(-48 * time.Hour)
+	require.Equal(t, int32(newCountryScore+newASNScore), RiskScore(newYork, now, history))
+
+	// logins without a location are not compared
+	unlocated := []Login{{CreatedAt: now.Add(-time.Hour)}, {CreatedAt: now.Add(-2 * time.Hour)}}
+	require.Zero(t, RiskScore(newYork, now, unlocated))
+
+	history = append(unlocated, history...)
+	require.Equal(t, int32(newCountryScore+newASNScore), RiskScore(newYork, now, history))
+}
@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// File is the declarative policy, the roles it lists and their
+// permissions are the whole content of the permissions table
+type File struct {
+	Roles []FileRole `yaml:"roles" json:"roles"`
+}
+
+type FileRole struct {
+	Name        string           `yaml:"name" json:"name"`
+	Description string           `yaml:"description" json:"description"`
+	Permissions []FilePermission `yaml:"permissions" json:"permissions"`
+}
+
+type FilePermission struct {
+	Resource  string `yaml:"resource" json:"resource"`
+	Action    string `yaml:"action" json:"action"`
+	Condition string `yaml:"condition" json:"condition"`
+	Effect    string `yaml:"effect" json:"effect"`
+}
+
+// Rule is a single row of the permissions table
+type Rule struct {
+	Role      string
+	Resource  string
+	Action    string
+	Condition string
+	Effect    string
+}
+
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s %s %s:%s", r.Effect, r.Role, r.Resource, r.Action)
+	if r.Condition != "" {
+		s += " if " + r.Condition
+	}
+	return s
+}
+
+// Diff is the change that turns the permissions table into the policy file
+type Diff struct {
+	Added   []Rule
+	Removed []Rule
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+func (d *Diff) String() string {
+	if d.Empty() {
+		return "no changes\n"
+	}
+
+	var b strings.Builder
+	for _, r := range d.Removed {
+		b.WriteString("- " + r.String() + "\n")
+	}
+	for _, r := range d.Added {
+		b.WriteString("+ " + r.String() + "\n")
+	}
+	return b.String()
+}
+
+// LoadFile reads a YAML or JSON policy file, chosen by its extension, and validates it
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	default:
+		return nil, fmt.Errorf("unsupported policy file %q, expected .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// Validate checks that every role and permission is complete,
+// known and listed once
+func (f *File) Validate() error {
+	roles := make(map[string]bool, len(f.Roles))
+	rules := make(map[Rule]bool)
+
+	for _, role := range f.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("role without a name")
+		}
+
+		if roles[role.Name] {
+			return fmt.Errorf("role %q is listed twice", role.Name)
+		}
+		roles[role.Name] = true
+
+		for _, p := range role.Permissions {
+			if p.Resource == "" || p.Action == "" {
+				return fmt.Errorf("role %q: resource and action are required", role.Name)
+			}
+
+			if p.Effect != "" && p.Effect != EffectAllow && p.Effect != EffectDeny {
+				return fmt.Errorf("role %q: unknown effect %q", role.Name, p.Effect)
+			}
+
+			if err := Validate(p.Condition); err != nil {
+				return fmt.Errorf("role %q: %w", role.Name, err)
+			}
+
+			rule := p.rule(role.Name)
+			if rules[rule] {
+				return fmt.Errorf("role %q: permission %s is listed twice", role.Name, rule)
+			}
+			rules[rule] = true
+		}
+	}
+
+	return nil
+}
+
+// Rules returns the permissions of the file as rows of the permissions table
+func (f *File) Rules() []Rule {
+	var rules []Rule
+
+	for _, role := range f.Roles {
+		for _, p := range role.Permissions {
+			rules = append(rules, p.rule(role.Name))
+		}
+	}
+
+	return rules
+}
+
+func (p FilePermission) rule(role string) Rule {
+	effect := p.Effect
+	if effect == "" {
+		effect = EffectAllow
+	}
+
+	return Rule{
+		Role:      role,
+		Resource:  p.Resource,
+		Action:    p.Action,
+		Condition: p.Condition,
+		Effect:    effect,
+	}
+}
+
+// Compare returns the rules to add to and remove from current to get desired
+func Compare(current, desired []Rule) *Diff {
+	var diff Diff
+
+	currentSet := make(map[Rule]bool, len(current))
+	for _, r := range current {
+		currentSet[r] = true
+	}
+
+	desiredSet := make(map[Rule]bool, len(desired))
+	for _, r := range desired {
+		desiredSet[r] = true
+		if !currentSet[r] {
+			diff.Added = append(diff.Added, r)
+		}
+	}
+
+	for _, r := range current {
+		if !desiredSet[r] {
+			diff.Removed = append(diff.Removed, r)
+		}
+	}
+
+	sortRules(diff.Added)
+	sortRules(diff.Removed)
+
+	return &diff
+}
+
+func sortRules(rules []Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].String() < rules[j].String()
+	})
+}
@@ -0,0 +1,84 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+roles:
+  - name: superadmin
+    permissions:
+      - resource: "*"
+        action: "*"
+  - name: user
+    description: Registered user
+    permissions:
+      - resource: posts
+        action: update
+        condition: owner_id == subject.id
+      - resource: posts/drafts
+        action: "*"
+        effect: deny
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	file, err := LoadFile(writeFile(t, "policy.yaml", testPolicy))
+	require.NoError(t, err)
+	require.Len(t, file.Roles, 2)
+	require.Equal(t, []Rule{
+		{Role: "superadmin", Resource: "*", Action: "*", Effect: EffectAllow},
+		{Role: "user", Resource: "posts", Action: "update", Condition: "owner_id == subject.id", Effect: EffectAllow},
+		{Role: "user", Resource: "posts/drafts", Action: "*", Effect: EffectDeny},
+	}, file.Rules())
+
+	file, err = LoadFile(writeFile(t, "policy.json", `{"roles": [{"name": "user", "permissions": [{"resource": "posts", "action": "create"}]}]}`))
+	require.NoError(t, err)
+	require.Equal(t, []Rule{{Role: "user", Resource: "posts", Action: "create", Effect: EffectAllow}}, file.Rules())
+
+	_, err = LoadFile(writeFile(t, "policy.toml", ""))
+	require.Error(t, err)
+}
+
+func TestFileValidate(t *testing.T) {
+	testCases := []struct {
+		name string
+		file File
+	}{
+		{"no role name", File{Roles: []FileRole{{}}}},
+		{"duplicate role", File{Roles: []FileRole{{Name: "user"}, {Name: "user"}}}},
+		{"no action", File{Roles: []FileRole{{Name: "user", Permissions: []FilePermission{{Resource: "posts"}}}}}},
+		{"unknown effect", File{Roles: []FileRole{{Name: "user", Permissions: []FilePermission{{Resource: "posts", Action: "create", Effect: "maybe"}}}}}},
+		{"invalid condition", File{Roles: []FileRole{{Name: "user", Permissions: []FilePermission{{Resource: "posts", Action: "create", Condition: "owner_id"}}}}}},
+		{"duplicate permission", File{Roles: []FileRole{{Name: "user", Permissions: []FilePermission{
+			{Resource: "posts", Action: "create"},
+			{Resource: "posts", Action: "create", Effect: EffectAllow},
+		}}}}},
+	}
+
+	for _, tc := range testCases {
+		require.Error(t, tc.file.Validate(), tc.name)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	keep := Rule{Role: "user", Resource: "posts", Action: "create", Effect: EffectAllow}
+	remove := Rule{Role: "user", Resource: "posts", Action: "delete", Effect: EffectAllow}
+	add := Rule{Role: "user", Resource: "posts", Action: "delete", Condition: "owner_id == subject.id", Effect: EffectAllow}
+
+	diff := Compare([]Rule{keep, remove}, []Rule{keep, add})
+	require.Equal(t, []Rule{add}, diff.Added)
+	require.Equal(t, []Rule{remove}, diff.Removed)
+	require.Equal(t, "- allow user posts:delete\n+ allow user posts:delete if owner_id == subject.id\n", diff.String())
+
+	require.True(t, Compare([]Rule{keep}, []Rule{keep}).Empty())
+}
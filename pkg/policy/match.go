@@ -0,0 +1,65 @@
+package policy
+
+import "strings"
+
+// Wildcard matches any action, any resource or any segment of a resource path
+const Wildcard = "*"
+
+// MatchAction checks if the action pattern of a permission covers the action
+func MatchAction(pattern, action string) bool {
+	return pattern == Wildcard || pattern == action
+}
+
+// MatchResource checks if the resource pattern of a permission covers the
+// resource. Resources are paths like "categories/123/posts", a pattern covers
+// the resources it matches segment by segment and everything below them, so
+// "categories/*" covers "categories/123" and "categories/123/posts".
+func MatchResource(pattern, resource string) bool {
+	if pattern == Wildcard || pattern == resource {
+		return true
+	}
+
+	patternSegments := strings.Split(pattern, "/")
+	resourceSegments := strings.Split(resource, "/")
+
+	if len(patternSegments) > len(resourceSegments) {
+		return false
+	}
+
+	for i, segment := range patternSegments {
+		if segment != Wildcard && segment != resourceSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// OverlapAction checks if some action is covered by both patterns
+func OverlapAction(a, b string) bool {
+	return a == Wildcard || b == Wildcard || a == b
+}
+
+// OverlapResource checks if some resource is covered by both patterns.
+// Since a pattern covers everything below it, it is enough that the segments
+// both patterns have match each other.
+func OverlapResource(a, b string) bool {
+	if a == Wildcard || b == Wildcard || a == b {
+		return true
+	}
+
+	aSegments := strings.Split(a, "/")
+	bSegments := strings.Split(b, "/")
+
+	if len(aSegments) > len(bSegments) {
+		aSegments, bSegments = bSegments, aSegments
+	}
+
+	for i, segment := range aSegments {
+		if segment != Wildcard && bSegments[i] != Wildcard && segment != bSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
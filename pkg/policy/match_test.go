@@ -0,0 +1,65 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchAction(t *testing.T) {
+	require.True(t, MatchAction("*", "create"))
+	require.True(t, MatchAction("create", "create"))
+	require.False(t, MatchAction("create", "update"))
+}
+
+func TestMatchResource(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		resource string
+		expected bool
+	}{
+		{"*", "posts", true},
+		{"*", "categories/123/posts", true},
+		{"posts", "posts", true},
+		{"posts", "users", false},
+		{"categories", "categories/123/posts", true},
+		{"categories/*", "categories/123", true},
+		{"categories/*/posts", "categories/123/posts", true},
+		{"categories/*/posts", "categories/123/comments", false},
+		{"categories/123", "categories/124/posts", false},
+		{"categories/123/posts", "categories/123", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, MatchResource(tc.pattern, tc.resource), "%s %s", tc.pattern, tc.resource)
+	}
+}
+
+func TestOverlapAction(t *testing.T) {
+	require.True(t, OverlapAction("*", "create"))
+	require.True(t, OverlapAction("create", "*"))
+	require.True(t, OverlapAction("create", "create"))
+	require.False(t, OverlapAction("create", "update"))
+}
+
+func TestOverlapResource(t *testing.T) {
+	testCases := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{"*", "posts", true},
+		{"posts", "*", true},
+		{"posts", "users", false},
+		{"categories", "categories/123/posts", true},
+		{"categories/123/posts", "categories", true},
+		{"categories/*/posts", "categories/123", true},
+		{"categories/123/*", "categories/*/posts", true},
+		{"categories/123/posts", "categories/124", false},
+		{"categories/*/posts", "categories/*/comments", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, OverlapResource(tc.a, tc.b), "%s %s", tc.a, tc.b)
+	}
+}
@@ -0,0 +1,261 @@
+// Package policy evaluates the conditions attached to permissions.
+//
+// A condition compares operands with ==, !=, <, <=, > and >=, and combines
+// comparisons with && and ||, && binding tighter. An operand is a quoted
+// string, a number, "now", "subject.id", "subject.email" or the name of a
+// resource attribute, optionally prefixed with "resource.":
+//
+//	owner_id == subject.id
+//	status == 'published' || owner_id == subject.id
+//	now < resource.editable_until
+//
+// Numbers and RFC3339 times are compared by value, anything else as strings.
+// A comparison with a missing resource attribute is false.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCondition = errors.New("invalid condition")
+
+// Subject is the user the permission is checked for
+type Subject struct {
+	ID    int64
+	Email string
+}
+
+type tokenKind int
+
+const (
+	tokenOperand tokenKind = iota
+	tokenLiteral
+	tokenOperator
+	tokenAnd
+	tokenOr
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+type comparison struct {
+	left, right token
+	operator    string
+}
+
+// condition is a disjunction of conjunctions of comparisons
+type condition [][]comparison
+
+// Validate checks that the condition can be evaluated
+func Validate(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+
+	_, err := parse(expr)
+	return err
+}
+
+// Evaluate evaluates the condition for the subject and the resource attributes.
+// An empty condition is always true.
+func Evaluate(expr string, subject Subject, attributes map[string]string, now time.Time) (bool, error) {
+	if strings.TrimSpace(expr) == "" {
+		return true, nil
+	}
+
+	cond, err := parse(expr)
+	if err != nil {
+		return false, err
+	}
+
+	for _, and := range cond {
+		ok := true
+		for _, cmp := range and {
+			if !cmp.evaluate(subject, attributes, now) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func parse(expr string) (condition, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cond condition
+		and  []comparison
+	)
+
+	for i := 0; ; {
+		if i+2 >= len(tokens) ||
+			(tokens[i].kind != tokenOperand && tokens[i].kind != tokenLiteral) ||
+			tokens[i+1].kind != tokenOperator ||
+			(tokens[i+2].kind != tokenOperand && tokens[i+2].kind != tokenLiteral) {
+			return nil, fmt.Errorf("%w: expected comparison in %q", ErrInvalidCondition, expr)
+		}
+
+		and = append(and, comparison{
+			left:     tokens[i],
+			operator: tokens[i+1].value,
+			right:    tokens[i+2],
+		})
+		i += 3
+
+		if i == len(tokens) {
+			cond = append(cond, and)
+			return cond, nil
+		}
+
+		switch tokens[i].kind {
+		case tokenAnd:
+		case tokenOr:
+			cond = append(cond, and)
+			and = nil
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q in %q", ErrInvalidCondition, tokens[i].value, expr)
+		}
+		i++
+	}
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated string in %q", ErrInvalidCondition, expr)
+			}
+			tokens = append(tokens, token{kind: tokenLiteral, value: expr[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, value: "&&"})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{kind: tokenOr, value: "||"})
+			i += 2
+		case strings.ContainsRune("=!<>", rune(c)):
+			op := string(c)
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("%w: unknown operator %q in %q", ErrInvalidCondition, op, expr)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, value: op})
+			i += len(op)
+		default:
+			start := i
+			for i < len(expr) && !strings.ContainsRune(" \t\n'\"&|=!<>", rune(expr[i])) {
+				i++
+			}
+			word := expr[start:i]
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				tokens = append(tokens, token{kind: tokenLiteral, value: word})
+			} else {
+				tokens = append(tokens, token{kind: tokenOperand, value: word})
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+func (c comparison) evaluate(subject Subject, attributes map[string]string, now time.Time) bool {
+	left, ok := c.left.resolve(subject, attributes, now)
+	if !ok {
+		return false
+	}
+
+	right, ok := c.right.resolve(subject, attributes, now)
+	if !ok {
+		return false
+	}
+
+	result := compare(left, right)
+
+	switch c.operator {
+	case "==":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+
+	return false
+}
+
+func (t token) resolve(subject Subject, attributes map[string]string, now time.Time) (string, bool) {
+	if t.kind == tokenLiteral {
+		return t.value, true
+	}
+
+	switch t.value {
+	case "now":
+		return now.Format(time.RFC3339), true
+	case "subject.id":
+		return strconv.FormatInt(subject.ID, 10), true
+	case "subject.email":
+		return subject.Email, true
+	}
+
+	value, ok := attributes[strings.TrimPrefix(t.value, "resource.")]
+	return value, ok
+}
+
+func compare(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if x, err := time.Parse(time.RFC3339, a); err == nil {
+		if y, err := time.Parse(time.RFC3339, b); err == nil {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,50 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(""))
+	require.NoError(t, Validate("owner_id == subject.id"))
+	require.NoError(t, Validate("status == 'published' || owner_id == subject.id && now < resource.editable_until"))
+
+	require.ErrorIs(t, Validate("owner_id"), ErrInvalidCondition)
+	require.ErrorIs(t, Validate("owner_id = subject.id"), ErrInvalidCondition)
+	require.ErrorIs(t, Validate("status == 'draft"), ErrInvalidCondition)
+	require.ErrorIs(t, Validate("owner_id == subject.id &&"), ErrInvalidCondition)
+}
+
+func TestEvaluate(t *testing.T) {
+	subject := Subject{ID: 7, Email: "author@example.com"}
+	now := time.Date(2023, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		condition  string
+		attributes map[string]string
+		expected   bool
+	}{
+		{"", nil, true},
+		{"owner_id == subject.id", map[string]string{"owner_id": "7"}, true},
+		{"owner_id == subject.id", map[string]string{"owner_id": "8"}, false},
+		{"owner_id == subject.id", nil, false},
+		{"owner_id != subject.id", nil, false},
+		{"resource.owner_id == subject.id", map[string]string{"owner_id": "7.0"}, true},
+		{"status == 'published' || owner_id == subject.id", map[string]string{"status": "published", "owner_id": "8"}, true},
+		{"status == 'draft' && owner_id == subject.id", map[string]string{"status": "published", "owner_id": "7"}, false},
+		{"views >= 10", map[string]string{"views": "9"}, false},
+		{"views < 10", map[string]string{"views": "9"}, true},
+		{"now < editable_until", map[string]string{"editable_until": "2023-01-10T13:00:00Z"}, true},
+		{"now < editable_until", map[string]string{"editable_until": "2023-01-10T16:00:00+05:00"}, false},
+		{"author == subject.email", map[string]string{"author": "author@example.com"}, true},
+	}
+
+	for _, tc := range testCases {
+		result, err := Evaluate(tc.condition, subject, tc.attributes, now)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, result, tc.condition)
+	}
+}
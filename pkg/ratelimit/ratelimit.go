@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const rateLimitKey = "rate_limit_"
+
+// Limit is a token bucket refilled with PerMinute tokens a minute
+// that holds at most Burst tokens
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Limiter takes a token from the bucket stored at key
+type Limiter interface {
+	TakeToken(key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// ParseLimits parses a comma separated list of method=per_minute:burst entries,
+// e.g. "/genproto.AuthService/Login=10:5", into a map of full method name to limit.
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		method, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(method, "/") {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		perMinute, burst, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		var (
+			limit Limit
+			err   error
+		)
+
+		limit.PerMinute, err = strconv.ParseFloat(perMinute, 64)
+		if err != nil || limit.PerMinute <= 0 {
+			return nil, fmt.Errorf("invalid rate of %q", entry)
+		}
+
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return nil, fmt.Errorf("invalid burst of %q", entry)
+		}
+
+		limits[method] = limit
+	}
+
+	return limits, nil
+}
+
+// UnaryServerInterceptor limits the methods listed in limits. Every method has
+// a bucket per client IP and one per authenticated user, a request needs a
+// token from each bucket it belongs to. Limiter errors let the request through.
+func UnaryServerInterceptor(limits map[string]Limit, limiter Limiter, cfg *config.Config, logger *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := check(ctx, info.FullMethod, limits, limiter, cfg, logger); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming methods,
+// a stream takes one token when it is opened
+func StreamServerInterceptor(limits map[string]Limit, limiter Limiter, cfg *config.Config, logger *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(ss.Context(), info.FullMethod, limits, limiter, cfg, logger); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// check takes a token for the request from the buckets of its method
+func check(ctx context.Context, method string, limits map[string]Limit, limiter Limiter, cfg *config.Config, logger *logrus.Logger) error {
+	limit, ok := limits[method]
+	if !ok {
+		return nil
+	}
+
+	ip, _ := utils.ClientInfo(ctx, cfg.TrustedProxies)
+	keys := []string{fmt.Sprintf("%s%s_ip_%s", rateLimitKey, method, ip)}
+
+	if token := utils.BearerToken(ctx); token != "" {
+		if payload, err := utils.VerifyToken(cfg, token); err == nil {
+			keys = append(keys, fmt.Sprintf("%s%s_user_%d", rateLimitKey, method, payload.UserID))
+		}
+	}
+
+	for _, key := range keys {
+		allowed, retryAfter, err := limiter.TakeToken(key, limit.PerMinute/60, limit.Burst)
+		if err != nil {
+			logger.WithError(err).Error("failed to check rate limit")
+			continue
+		}
+
+		if !allowed {
+			st, err := status.New(codes.ResourceExhausted, "rate_limit_exceeded").WithDetails(&errdetails.RetryInfo{
+				RetryDelay: durationpb.New(retryAfter),
+			})
+			if err != nil {
+				return status.Error(codes.ResourceExhausted, "rate_limit_exceeded")
+			}
+			return st.Err()
+		}
+	}
+
+	return nil
+}
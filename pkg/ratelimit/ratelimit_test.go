@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type fakeLimiter struct {
+	tokens map[string]int
+}
+
+func (f *fakeLimiter) TakeToken(key string, rate float64, burst int) (bool, time.Duration, error) {
+	if _, ok := f.tokens[key]; !ok {
+		f.tokens[key] = burst
+	}
+
+	if f.tokens[key] == 0 {
+		return false, time.Second, nil
+	}
+
+	f.tokens[key]--
+	return true, 0, nil
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("/genproto.AuthService/Login=10:5, /genproto.AuthService/Register=0.5:1")
+	require.NoError(t, err)
+	require.Equal(t, Limit{PerMinute: 10, Burst: 5}, limits["/genproto.AuthService/Login"])
+	require.Equal(t, Limit{PerMinute: 0.5, Burst: 1}, limits["/genproto.AuthService/Register"])
+
+	_, err = ParseLimits("/genproto.AuthService/Login=10")
+	require.Error(t, err)
+
+	_, err = ParseLimits("Login=10:5")
+	require.Error(t, err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	interceptor := UnaryServerInterceptor(
+		map[string]Limit{"/genproto.AuthService/Login": {PerMinute: 1, Burst: 2}},
+		&fakeLimiter{tokens: make(map[string]int)},
+		&config.Config{AuthSecretKey: "secret"},
+		logger,
+	)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	login := &grpc.UnaryServerInfo{FullMethod: "/genproto.AuthService/Login"}
+
+	for i := 0; i < 2; i++ {
+		_, err := interceptor(context.Background(), nil, login, handler)
+		require.NoError(t, err)
+	}
+
+	_, err := interceptor(context.Background(), nil, login, handler)
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	require.Equal(t, time.Second, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	// methods without a limit are not counted
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/genproto.AuthService/Register"}, handler)
+	require.NoError(t, err)
+}
+
+func TestBuckets(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{AuthSecretKey: "secret"}
+	limits := map[string]Limit{"/genproto.UserService/ExportUsers": {PerMinute: 1, Burst: 1}}
+	interceptor := StreamServerInterceptor(limits, &fakeLimiter{tokens: make(map[string]int)}, cfg, logger)
+
+	token, _, err := utils.CreateToken(cfg, &utils.TokenParams{UserID: 1, Duration: time.Minute})
+	require.NoError(t, err)
+
+	fromIP := func(ip string, withToken bool) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5432},
+		})
+		if withToken {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+		}
+		return ctx
+	}
+
+	handler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	export := &grpc.StreamServerInfo{FullMethod: "/genproto.UserService/ExportUsers"}
+
+	require.NoError(t, interceptor(nil, &fakeStream{ctx: fromIP("10.0.0.1", true)}, export, handler))
+
+	// the user is limited from any address
+	err = interceptor(nil, &fakeStream{ctx: fromIP("10.0.0.2", true)}, export, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// and the address for any caller
+	err = interceptor(nil, &fakeStream{ctx: fromIP("10.0.0.1", false)}, export, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	require.NoError(t, interceptor(nil, &fakeStream{ctx: fromIP("10.0.0.3", false)}, export, handler))
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
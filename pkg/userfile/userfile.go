@@ -0,0 +1,172 @@
+// Package userfile reads the CSV and JSONL files users are imported from and
+// writes the CSV, JSONL and Parquet files they are exported to
+package userfile
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Row is a user of a file. Password is a plain text password, PasswordHash
+// a bcrypt hash, at most one of them is expected.
+type Row struct {
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	PhoneNumber  string `json:"phone_number"`
+	Gender       string `json:"gender"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+	Type         string `json:"type"`
+}
+
+// Columns are the CSV columns, the header may list them in any order and
+// leave the optional ones out
+var Columns = []string{
+	"first_name",
+	"last_name",
+	"email",
+	"phone_number",
+	"gender",
+	"username",
+	"password",
+	"password_hash",
+	"type",
+}
+
+// FormatOf returns the format of the file by its extension
+func FormatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	case ".parquet":
+		return FormatParquet, nil
+	default:
+		return "", fmt.Errorf("unsupported file %q, expected .csv, .jsonl or .parquet", path)
+	}
+}
+
+// Reader reads the rows of a file one by one, Read returns io.EOF after the last row
+type Reader interface {
+	Read() (*Row, error)
+}
+
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+
+	known := make(map[string]bool, len(Columns))
+	for _, column := range Columns {
+		known[column] = true
+	}
+
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !known[header[i]] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	return &csvReader{reader: reader, columns: header}, nil
+}
+
+func (c *csvReader) Read() (*Row, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var row Row
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+
+		switch c.columns[i] {
+		case "first_name":
+			row.FirstName = value
+		case "last_name":
+			row.LastName = value
+		case "email":
+			row.Email = value
+		case "phone_number":
+			row.PhoneNumber = value
+		case "gender":
+			row.Gender = value
+		case "username":
+			row.Username = value
+		case "password":
+			row.Password = value
+		case "password_hash":
+			row.PasswordHash = value
+		case "type":
+			row.Type = value
+		}
+	}
+
+	return &row, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (j *jsonlReader) Read() (*Row, error) {
+	for j.scanner.Scan() {
+		j.line++
+
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var row Row
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("line %d: %w", j.line, err)
+		}
+
+		return &row, nil
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
@@ -0,0 +1,73 @@
+package userfile
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, reader Reader) []*Row {
+	rows := make([]*Row, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return rows
+		}
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	input := "email,first_name,last_name,password_hash\n" +
+		"ali@example.com, Ali ,Valiyev,$2a$10$hash\n" +
+		"\"vali@example.com\",Vali,\"Aliyev, Jr\",\n"
+
+	reader, err := NewReader(strings.NewReader(input), FormatCSV)
+	require.NoError(t, err)
+
+	rows := readAll(t, reader)
+	require.Len(t, rows, 2)
+	require.Equal(t, Row{Email: "ali@example.com", FirstName: "Ali", LastName: "Valiyev", PasswordHash: "$2a$10$hash"}, *rows[0])
+	require.Equal(t, "Aliyev, Jr", rows[1].LastName)
+
+	_, err = NewReader(strings.NewReader("email,age\n"), FormatCSV)
+	require.Error(t, err)
+}
+
+func TestJSONLReader(t *testing.T) {
+	input := `{"email":"ali@example.com","first_name":"Ali","last_name":"Valiyev","password":"secret123"}
+
+{"email":"vali@example.com","first_name":"Vali","last_name":"Aliyev"}
+not json
+`
+
+	reader, err := NewReader(strings.NewReader(input), FormatJSONL)
+	require.NoError(t, err)
+
+	row, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "secret123", row.Password)
+
+	row, err = reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "vali@example.com", row.Email)
+
+	_, err = reader.Read()
+	require.ErrorContains(t, err, "line 4")
+}
+
+func TestFormatOf(t *testing.T) {
+	format, err := FormatOf("users.CSV")
+	require.NoError(t, err)
+	require.Equal(t, FormatCSV, format)
+
+	format, err = FormatOf("users.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, FormatJSONL, format)
+
+	_, err = FormatOf("users.xlsx")
+	require.Error(t, err)
+}
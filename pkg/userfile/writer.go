@@ -0,0 +1,133 @@
+package userfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize bounds the rows a Parquet writer holds in memory
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+// User is an exported user, it never has a password
+type User struct {
+	ID              int64  `json:"id" parquet:"name=id, type=INT64"`
+	FirstName       string `json:"first_name" parquet:"name=first_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LastName        string `json:"last_name" parquet:"name=last_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Email           string `json:"email" parquet:"name=email, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PhoneNumber     string `json:"phone_number" parquet:"name=phone_number, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Gender          string `json:"gender" parquet:"name=gender, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Username        string `json:"username" parquet:"name=username, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ProfileImageUrl string `json:"profile_image_url" parquet:"name=profile_image_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type            string `json:"type" parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Verified        bool   `json:"verified" parquet:"name=verified, type=BOOLEAN"`
+	CreatedAt       string `json:"created_at" parquet:"name=created_at, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// ExportColumns are the CSV columns of exported users
+var ExportColumns = []string{
+	"id",
+	"first_name",
+	"last_name",
+	"email",
+	"phone_number",
+	"gender",
+	"username",
+	"profile_image_url",
+	"type",
+	"verified",
+	"created_at",
+}
+
+// Writer writes users one by one, Close writes what is buffered and
+// leaves the underlying writer open
+type Writer interface {
+	Write(user *User) error
+	Close() error
+}
+
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return newParquetWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ExportColumns); err != nil {
+		return nil, err
+	}
+
+	return &csvWriter{writer: writer}, nil
+}
+
+func (c *csvWriter) Write(user *User) error {
+	return c.writer.Write([]string{
+		strconv.FormatInt(user.ID, 10),
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.PhoneNumber,
+		user.Gender,
+		user.Username,
+		user.ProfileImageUrl,
+		user.Type,
+		strconv.FormatBool(user.Verified),
+		user.CreatedAt,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonlWriter) Write(user *User) error {
+	return j.encoder.Encode(user)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+func newParquetWriter(w io.Writer) (*parquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(User), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	pw.RowGroupSize = parquetRowGroupSize
+
+	return &parquetWriter{writer: pw}, nil
+}
+
+func (p *parquetWriter) Write(user *User) error {
+	return p.writer.Write(*user)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.writer.WriteStop()
+}
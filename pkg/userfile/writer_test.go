@@ -0,0 +1,53 @@
+package userfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var exported = []*User{
+	{ID: 1, FirstName: "Ali", LastName: "Valiyev", Email: "ali@example.com", Type: "user", Verified: true, CreatedAt: "2022-11-05T10:30:00Z"},
+	{ID: 2, FirstName: "Vali", LastName: "Aliyev, Jr", Email: "vali@example.com", Type: "user", CreatedAt: "2022-11-06T10:30:00Z"},
+}
+
+func writeAll(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, format)
+	require.NoError(t, err)
+
+	for _, user := range exported {
+		require.NoError(t, w.Write(user))
+	}
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeAll(t, FormatCSV))), "\n")
+
+	require.Len(t, lines, 3)
+	require.Equal(t, strings.Join(ExportColumns, ","), lines[0])
+	require.Equal(t, "1,Ali,Valiyev,ali@example.com,,,,,user,true,2022-11-05T10:30:00Z", lines[1])
+	require.Contains(t, lines[2], `"Aliyev, Jr"`)
+}
+
+func TestJSONLWriter(t *testing.T) {
+	reader, err := NewReader(bytes.NewReader(writeAll(t, FormatJSONL)), FormatJSONL)
+	require.NoError(t, err)
+
+	row, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "ali@example.com", row.Email)
+}
+
+func TestParquetWriter(t *testing.T) {
+	data := writeAll(t, FormatParquet)
+
+	require.True(t, bytes.HasPrefix(data, []byte("PAR1")))
+	require.True(t, bytes.HasSuffix(data, []byte("PAR1")))
+}
@@ -0,0 +1,137 @@
+// Package username checks usernames against the allowed characters, the
+// length limits and the reserved names, and suggests free alternatives
+package username
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultReserved are the names taken by the blog itself
+var DefaultReserved = []string{
+	"about",
+	"admin",
+	"administrator",
+	"api",
+	"blog",
+	"help",
+	"login",
+	"logout",
+	"mail",
+	"me",
+	"moderator",
+	"null",
+	"official",
+	"register",
+	"root",
+	"security",
+	"settings",
+	"signup",
+	"staff",
+	"superadmin",
+	"support",
+	"system",
+	"undefined",
+	"www",
+}
+
+// Rules are the usernames users can choose
+type Rules struct {
+	chars     *regexp.Regexp
+	minLength int
+	maxLength int
+	reserved  map[string]bool
+}
+
+// NewRules returns the rules of usernames made of the characters of the
+// charset, a regexp character class body like "a-z0-9_", with the length
+// limits in characters. Reserved names are compared case-insensitively.
+func NewRules(charset string, minLength, maxLength int, reserved []string) (*Rules, error) {
+	chars, err := regexp.Compile("^[" + charset + "]$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid charset %q: %w", charset, err)
+	}
+
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid length limits %d-%d", minLength, maxLength)
+	}
+
+	rules := Rules{
+		chars:     chars,
+		minLength: minLength,
+		maxLength: maxLength,
+		reserved:  make(map[string]bool, len(reserved)),
+	}
+
+	for _, name := range reserved {
+		rules.reserved[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	return &rules, nil
+}
+
+// Validate checks the characters and the length of the username
+func (r *Rules) Validate(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < r.minLength || length > r.maxLength {
+		return fmt.Errorf("username must have %d to %d characters", r.minLength, r.maxLength)
+	}
+
+	for _, c := range username {
+		if !r.chars.MatchString(string(c)) {
+			return fmt.Errorf("username can not contain %q", c)
+		}
+	}
+
+	return nil
+}
+
+// IsReserved reports whether the username is reserved in any case
+func (r *Rules) IsReserved(username string) bool {
+	return r.reserved[strings.ToLower(username)]
+}
+
+// Candidates returns usernames to suggest instead of the username, made of
+// its allowed characters followed by numbers. They are valid and not
+// reserved but may be taken.
+func (r *Rules) Candidates(username string, count int) []string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(username) {
+		if r.chars.MatchString(string(c)) {
+			b.WriteRune(c)
+		}
+	}
+	base := b.String()
+
+	suffixes := make([]string, 0, count)
+	for n := 1; n <= count/2; n++ {
+		suffixes = append(suffixes, strconv.Itoa(n))
+	}
+	for len(suffixes) < count {
+		suffixes = append(suffixes, strconv.Itoa(100+rand.Intn(9900)))
+	}
+
+	candidates := make([]string, 0, count)
+	seen := make(map[string]bool, count)
+
+	for _, suffix := range suffixes {
+		prefix := []rune(base)
+		if room := r.maxLength - utf8.RuneCountInString(suffix); len(prefix) > room {
+			prefix = prefix[:room]
+		}
+
+		candidate := string(prefix) + suffix
+		if seen[candidate] || r.Validate(candidate) != nil || r.IsReserved(candidate) {
+			continue
+		}
+
+		seen[candidate] = true
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
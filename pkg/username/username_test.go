@@ -0,0 +1,45 @@
+package username
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := NewRules("a-zA-Z0-9_.", 3, 10, DefaultReserved)
+	require.NoError(t, err)
+
+	require.NoError(t, rules.Validate("ali_valiye"))
+	require.NoError(t, rules.Validate("Ali.V"))
+	require.Error(t, rules.Validate("al"))
+	require.Error(t, rules.Validate("ali_valiyev"))
+	require.Error(t, rules.Validate("ali vali"))
+	require.Error(t, rules.Validate("alí"))
+
+	require.True(t, rules.IsReserved("Admin"))
+	require.True(t, rules.IsReserved("SUPPORT"))
+	require.False(t, rules.IsReserved("ali"))
+
+	_, err = NewRules("z-a", 3, 10, nil)
+	require.Error(t, err)
+
+	_, err = NewRules("a-z", 5, 3, nil)
+	require.Error(t, err)
+}
+
+func TestCandidates(t *testing.T) {
+	rules, err := NewRules("a-z0-9_", 3, 8, DefaultReserved)
+	require.NoError(t, err)
+
+	candidates := rules.Candidates("Ali Valiyev!", 6)
+	require.Len(t, candidates, 6)
+	require.Equal(t, "alivali1", candidates[0])
+
+	for _, candidate := range candidates {
+		require.NoError(t, rules.Validate(candidate))
+	}
+
+	// "admin" followed by a number is not reserved
+	require.Contains(t, rules.Candidates("admin", 2), "admin1")
+}
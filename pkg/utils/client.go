@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientInfo returns the client IP address and user agent of the incoming
+// request. The service is reached through trustedProxies proxies, each of them
+// appends the address it was reached from to x-forwarded-for, so the client
+// is the trustedProxies-th address from the end. Addresses before it are set
+// by the client and are ignored, as is x-forwarded-for without trusted proxies.
+func ClientInfo(ctx context.Context, trustedProxies int) (ip, userAgent string) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		if trustedProxies > 0 {
+			var forwarded []string
+			for _, value := range md.Get("x-forwarded-for") {
+				for _, addr := range strings.Split(value, ",") {
+					forwarded = append(forwarded, strings.TrimSpace(addr))
+				}
+			}
+
+			if len(forwarded) >= trustedProxies {
+				ip = forwarded[len(forwarded)-trustedProxies]
+			}
+		}
+		if values := md.Get("user-agent"); len(values) > 0 {
+			userAgent = values[0]
+		}
+	}
+
+	if ip == "" {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			ip = p.Addr.String()
+			if host, _, err := net.SplitHostPort(ip); err == nil {
+				ip = host
+			}
+		}
+	}
+
+	return ip, userAgent
+}
+
+// BearerToken returns the token of the authorization metadata of the incoming request
+func BearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	token := values[0]
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = token[7:]
+	}
+
+	return strings.TrimSpace(token)
+}
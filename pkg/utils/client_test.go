@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientInfo(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5432},
+	})
+
+	ip, userAgent := ClientInfo(ctx, 1)
+	require.Equal(t, "10.0.0.1", ip)
+	require.Empty(t, userAgent)
+
+	// the client set the first address, the gateway appended the second
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"x-forwarded-for", "198.51.100.1, 203.0.113.7",
+		"user-agent", "Mozilla/5.0",
+	))
+
+	ip, userAgent = ClientInfo(ctx, 1)
+	require.Equal(t, "203.0.113.7", ip)
+	require.Equal(t, "Mozilla/5.0", userAgent)
+
+	ip, _ = ClientInfo(ctx, 2)
+	require.Equal(t, "198.51.100.1", ip)
+
+	// without trusted proxies the header is not believed
+	ip, _ = ClientInfo(ctx, 0)
+	require.Equal(t, "10.0.0.1", ip)
+
+	ip, _ = ClientInfo(ctx, 3)
+	require.Equal(t, "10.0.0.1", ip)
+}
@@ -28,6 +28,14 @@ func NullInt32(v int32) (ni sql.NullInt32) {
 	return ni
 }
 
+func NullInt64(v int64) (ni sql.NullInt64) {
+	if v != 0 {
+		ni.Int64 = v
+		ni.Valid = true
+	}
+	return ni
+}
+
 func FormatNullTime(nt sql.NullTime, format string) string {
 	if nt.Valid {
 		return nt.Time.Format(format)
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageToken(t *testing.T) {
+	type position struct {
+		CreatedAt time.Time
+		ID        int64
+	}
+
+	want := position{CreatedAt: time.Date(2022, 11, 5, 10, 30, 0, 123456000, time.UTC), ID: 42}
+
+	token, err := EncodePageToken(want)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	var got position
+	require.NoError(t, DecodePageToken(token, &got))
+	require.True(t, want.CreatedAt.Equal(got.CreatedAt))
+	require.Equal(t, want.ID, got.ID)
+
+	require.Error(t, DecodePageToken("not a token!", &got))
+}
@@ -18,9 +18,22 @@ type Payload struct {
 	ID        uuid.UUID `json:"id"`
 	UserID    int64     `json:"user_id"`
 	Email     string    `json:"email"`
-	UserType  string    `json:"type"`
+	Roles     []string  `json:"roles"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiredAt time.Time `json:"expired_at"`
+	// UserType is the claim tokens had before roles, it is still set
+	// for the services that read it
+	UserType string `json:"type,omitempty"`
+	// RolesVersion is the version of the roles of the user the token was issued with
+	RolesVersion int64 `json:"roles_version,omitempty"`
+	// PermissionVersion is the version of the permission set the token was
+	// issued with, Permissions lists the unconditional "resource:action" grants
+	PermissionVersion int64    `json:"permission_version,omitempty"`
+	Permissions       []string `json:"permissions,omitempty"`
+	// OrganizationID is the organization the token acts in,
+	// OrganizationRoles the roles of the user there
+	OrganizationID    int64    `json:"organization_id,omitempty"`
+	OrganizationRoles []string `json:"organization_roles,omitempty"`
 }
 
 // NewPayload creates a new token payload
@@ -34,9 +47,16 @@ func NewPayload(params *TokenParams) (*Payload, error) {
 		ID:        tokenID,
 		UserID:    params.UserID,
 		Email:     params.Email,
-		UserType:  params.UserType,
+		Roles:     params.Roles,
 		IssuedAt:  time.Now(),
 		ExpiredAt: time.Now().Add(params.Duration),
+		UserType:  params.UserType,
+
+		RolesVersion:      params.RolesVersion,
+		PermissionVersion: params.PermissionVersion,
+		Permissions:       params.Permissions,
+		OrganizationID:    params.OrganizationID,
+		OrganizationRoles: params.OrganizationRoles,
 	}
 	return payload, nil
 }
@@ -48,3 +68,13 @@ func (payload *Payload) Valid() error {
 	}
 	return nil
 }
+
+// HasRole checks if the token was issued to a user with the role
+func (payload *Payload) HasRole(role string) bool {
+	for _, r := range payload.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
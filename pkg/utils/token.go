@@ -13,7 +13,14 @@ type TokenParams struct {
 	Username string
 	Email    string
 	UserType string
+	Roles    []string
 	Duration time.Duration
+
+	RolesVersion      int64
+	PermissionVersion int64
+	Permissions       []string
+	OrganizationID    int64
+	OrganizationRoles []string
 }
 
 // CreateToken creates a new token
@@ -52,5 +59,10 @@ func VerifyToken(cfg *config.Config, token string) (*Payload, error) {
 		return nil, ErrInvalidToken
 	}
 
+	// tokens issued before roles only carry the user type
+	if len(payload.Roles) == 0 && payload.UserType != "" {
+		payload.Roles = []string{payload.UserType}
+	}
+
 	return payload, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/ibrat-muslim/blog_app_user_service/config"
 	pbn "github.com/ibrat-muslim/blog_app_user_service/genproto/notification_service"
 	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/geoip"
 	grpcPkg "github.com/ibrat-muslim/blog_app_user_service/pkg/grpc_client"
 	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
 	"github.com/ibrat-muslim/blog_app_user_service/storage"
@@ -22,8 +23,17 @@ import (
 )
 
 const (
-	RegisterCodeKey   = "register_code_"
-	ForgotPasswordKey = "forgot_password_code_"
+	RegisterCodeKey      = "register_code_"
+	ForgotPasswordKey    = "forgot_password_code_"
+	LoginConfirmationKey = "login_confirmation_code_"
+
+	// loginConfirmationAttemptsKey counts the wrong codes sent to VerifyLogin,
+	// the code is dropped after maxLoginConfirmationAttempts of them
+	loginConfirmationAttemptsKey = "login_confirmation_attempts_"
+	maxLoginConfirmationAttempts = 5
+
+	// loginHistorySize is the number of previous logins a new login is compared with
+	loginHistorySize = 50
 )
 
 type AuthService struct {
@@ -33,19 +43,34 @@ type AuthService struct {
 	grpcClient grpcPkg.GrpcClientI
 	cfg        *config.Config
 	logger     *logrus.Logger
+	notifier   *notifier
+	geo        *geoip.Reader
+	captcha    *CaptchaVerifiers
 }
 
-func NewAuthService(strg storage.StorageI, inMemory storage.InMemoryStorageI, grpcClient grpcPkg.GrpcClientI, cfg *config.Config, logger *logrus.Logger) *AuthService {
+func NewAuthService(strg storage.StorageI, inMemory storage.InMemoryStorageI, grpcClient grpcPkg.GrpcClientI, geo *geoip.Reader, captcha *CaptchaVerifiers, cfg *config.Config, logger *logrus.Logger) *AuthService {
 	return &AuthService{
 		storage:    strg,
 		inMemory:   inMemory,
 		grpcClient: grpcClient,
+		geo:        geo,
+		captcha:    captcha,
 		cfg:        cfg,
 		logger:     logger,
+		notifier: &notifier{
+			storage:    strg,
+			inMemory:   inMemory,
+			grpcClient: grpcClient,
+			logger:     logger,
+		},
 	}
 }
 
 func (s *AuthService) Register(ctx context.Context, req *pbu.RegisterRequest) (*emptypb.Empty, error) {
+	if err := s.verifyCaptcha(ctx, "Register", req.CaptchaToken); err != nil {
+		return nil, err
+	}
+
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to hash password: %v", err)
@@ -127,17 +152,20 @@ func (s *AuthService) Verify(ctx context.Context, req *pbu.VerifyRequest) (*pbu.
 		return nil, status.Error(codes.Internal, "incorrect_code")
 	}
 
+	user.Verified = true
+
 	result, err := s.storage.User().Create(&user)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to create user: %v", err)
 	}
 
-	token, _, err := utils.CreateToken(s.cfg, &utils.TokenParams{
-		UserID:   result.ID,
-		UserType: result.Type,
-		Email:    result.Email,
-		Duration: time.Hour * 24,
-	})
+	ip, userAgent := utils.ClientInfo(ctx, s.cfg.TrustedProxies)
+	go func() {
+		s.notifier.rememberDevice(result.ID, ip, userAgent)
+		s.notifier.notify(result, EmailTypeWelcome, nil)
+	}()
+
+	token, payload, err := s.createToken(result, 0, time.Hour*24)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to create token: %v", err)
 	}
@@ -151,47 +179,261 @@ func (s *AuthService) Verify(ctx context.Context, req *pbu.VerifyRequest) (*pbu.
 		Type:        result.Type,
 		CreatedAt:   result.CreatedAt.Format(time.RFC3339),
 		AccessToken: token,
+		Roles:       payload.Roles,
 	}, nil
 }
 
 func (s *AuthService) Login(ctx context.Context, req *pbu.LoginRequest) (*pbu.AuthResponse, error) {
+	if err := s.verifyCaptcha(ctx, "Login", req.CaptchaToken); err != nil {
+		return nil, err
+	}
+
+	event := &repo.LoginEvent{
+		Email: req.Email,
+		Type:  repo.LoginEventTypeLogin,
+	}
+
 	result, err := s.storage.User().GetByEmail(req.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			event.Reason = "user_not_found"
+			s.recordLoginEvent(ctx, event)
 			return nil, status.Errorf(codes.Internal, "wrong email or password: %v", err)
 		}
 
+		event.Reason = "internal_error"
+		s.recordLoginEvent(ctx, event)
 		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
 	}
 
+	event.UserID = result.ID
+
 	err = utils.CheckPassword(req.Password, result.Password)
 	if err != nil {
+		event.Reason = "wrong_password"
+		s.recordLoginEvent(ctx, event)
 		return nil, status.Errorf(codes.Internal, "wrong email or password: %v", err)
 	}
 
-	token, _, err := utils.CreateToken(s.cfg, &utils.TokenParams{
-		UserID:   result.ID,
-		UserType: result.Type,
-		Email:    result.Email,
-		Duration: time.Hour * 24,
-	})
+	s.assessLogin(ctx, event)
+
+	if event.RiskScore >= s.cfg.SuspiciousLoginScore {
+		event.Reason = "confirmation_required"
+		s.recordLoginEvent(ctx, event)
+
+		if err := s.inMemory.Delete(loginConfirmationAttemptsKey + result.Email); err != nil {
+			return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		}
+
+		go func() {
+			err := s.sendVerificationCode(LoginConfirmationKey, result.Email)
+			if err != nil {
+				s.logger.WithError(err).Error("failed to send login confirmation code")
+			}
+		}()
+
+		return nil, status.Error(codes.FailedPrecondition, "login_confirmation_required")
+	}
+
+	return s.completeLogin(ctx, result, event)
+}
+
+// VerifyLogin issues the token of a suspicious login once the user
+// confirms it with the code sent to their email.
+func (s *AuthService) VerifyLogin(ctx context.Context, req *pbu.VerifyRequest) (*pbu.AuthResponse, error) {
+	code, err := s.inMemory.Get(LoginConfirmationKey + req.Email)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "code_expired")
+	}
+
+	if req.Code != code {
+		attempts, err := s.inMemory.Incr(loginConfirmationAttemptsKey+req.Email, time.Minute)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		}
+
+		if attempts >= maxLoginConfirmationAttempts {
+			if err := s.inMemory.Delete(LoginConfirmationKey + req.Email); err != nil {
+				return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+			}
+			return nil, status.Error(codes.ResourceExhausted, "too_many_attempts")
+		}
+
+		return nil, status.Error(codes.Internal, "incorrect_code")
+	}
+
+	// the code confirms a single login
+	if err := s.inMemory.Delete(LoginConfirmationKey + req.Email); err != nil {
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+	if err := s.inMemory.Delete(loginConfirmationAttemptsKey + req.Email); err != nil {
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	result, err := s.storage.User().GetByEmail(req.Email)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
 	}
 
+	event := &repo.LoginEvent{
+		UserID: result.ID,
+		Email:  result.Email,
+		Type:   repo.LoginEventTypeLogin,
+		Reason: "confirmed_by_email",
+	}
+	s.assessLogin(ctx, event)
+
+	return s.completeLogin(ctx, result, event)
+}
+
+func (s *AuthService) completeLogin(ctx context.Context, user *repo.User, event *repo.LoginEvent) (*pbu.AuthResponse, error) {
+	token, payload, err := s.createToken(user, 0, time.Hour*24)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	event.Success = true
+	s.recordLoginEvent(ctx, event)
+
+	ip, userAgent := utils.ClientInfo(ctx, s.cfg.TrustedProxies)
+	go func() {
+		if !s.notifier.rememberDevice(user.ID, ip, userAgent) {
+			s.notifier.notify(user, EmailTypeNewLogin, map[string]string{
+				"ip":         ip,
+				"user_agent": userAgent,
+				"country":    event.Country,
+				"time":       time.Now().Format(time.RFC1123),
+			})
+		}
+	}()
+
 	return &pbu.AuthResponse{
-		Id:          result.ID,
-		FirstName:   result.FirstName,
-		LastName:    result.LastName,
-		Username:    result.Username,
-		Email:       result.Email,
-		Type:        result.Type,
-		CreatedAt:   result.CreatedAt.Format(time.RFC3339),
+		Id:          user.ID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Username:    user.Username,
+		Email:       user.Email,
+		Type:        user.Type,
+		CreatedAt:   user.CreatedAt.Format(time.RFC3339),
 		AccessToken: token,
+		Roles:       payload.Roles,
 	}, nil
 }
 
+// assessLogin locates the client and scores the login against
+// the user's login history. It does nothing without a GeoIP database.
+func (s *AuthService) assessLogin(ctx context.Context, event *repo.LoginEvent) {
+	if s.geo == nil {
+		return
+	}
+
+	ip, _ := utils.ClientInfo(ctx, s.cfg.TrustedProxies)
+
+	location, err := s.geo.Lookup(ip)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to look up ip location")
+		return
+	}
+
+	event.Country = location.Country
+	event.ASN = location.ASN
+	event.Latitude = location.Latitude
+	event.Longitude = location.Longitude
+
+	history, err := s.storage.LoginEvent().GetRecentSuccessful(event.UserID, loginHistorySize)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get login history")
+		return
+	}
+
+	logins := make([]geoip.Login, 0, len(history))
+	for _, e := range history {
+		logins = append(logins, geoip.Login{
+			Location: geoip.Location{
+				Country:   e.Country,
+				ASN:       e.ASN,
+				Latitude:  e.Latitude,
+				Longitude: e.Longitude,
+			},
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	event.RiskScore = geoip.RiskScore(location, time.Now(), logins)
+}
+
+// createToken issues an access token carrying the roles of the user,
+// and their roles in the organization unless organizationID is zero
+func (s *AuthService) createToken(user *repo.User, organizationID int64, duration time.Duration) (string, *utils.Payload, error) {
+	roles, err := s.storage.Role().GetUserRoles(user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	params := utils.TokenParams{
+		UserID:   user.ID,
+		Email:    user.Email,
+		UserType: user.Type,
+		Roles:    roles,
+		Duration: duration,
+	}
+
+	params.RolesVersion, err = s.storage.Role().GetRolesVersion(user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if organizationID != 0 {
+		params.OrganizationID = organizationID
+		params.OrganizationRoles, err = s.storage.Organization().GetMemberRoles(organizationID, user.ID)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if s.cfg.TokenPermissions == TokenPermissionsVersion || s.cfg.TokenPermissions == TokenPermissionsList {
+		params.PermissionVersion, err = s.storage.Permission().GetVersion()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if s.cfg.TokenPermissions == TokenPermissionsList {
+		params.Permissions, err = compactPermissions(s.storage, roles)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	token, payload, err := utils.CreateToken(s.cfg, &params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, payload, nil
+}
+
+func (s *AuthService) recordLoginEvent(ctx context.Context, event *repo.LoginEvent) {
+	event.IPAddress, event.UserAgent = utils.ClientInfo(ctx, s.cfg.TrustedProxies)
+
+	_, err := s.storage.LoginEvent().Create(event)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to record login event")
+	}
+}
+
 func (s *AuthService) ForgotPassword(ctx context.Context, req *pbu.ForgotPasswordRequest) (*emptypb.Empty, error) {
+	if err := s.verifyCaptcha(ctx, "ForgotPassword", req.CaptchaToken); err != nil {
+		return nil, err
+	}
+
+	s.recordLoginEvent(ctx, &repo.LoginEvent{
+		Email:   req.Email,
+		Type:    repo.LoginEventTypePasswordReset,
+		Success: true,
+		Reason:  "code_requested",
+	})
+
 	go func() {
 		err := s.sendVerificationCode(ForgotPasswordKey, req.Email)
 		if err != nil {
@@ -203,12 +445,21 @@ func (s *AuthService) ForgotPassword(ctx context.Context, req *pbu.ForgotPasswor
 }
 
 func (s *AuthService) VerifyForgotPassword(ctx context.Context, req *pbu.VerifyRequest) (*pbu.AuthResponse, error) {
+	event := &repo.LoginEvent{
+		Email: req.Email,
+		Type:  repo.LoginEventTypePasswordReset,
+	}
+
 	code, err := s.inMemory.Get(ForgotPasswordKey + req.Email)
 	if err != nil {
+		event.Reason = "code_expired"
+		s.recordLoginEvent(ctx, event)
 		return nil, status.Errorf(codes.Internal, "code expired: %v", err)
 	}
 
 	if req.Code != code {
+		event.Reason = "incorrect_code"
+		s.recordLoginEvent(ctx, event)
 		return nil, status.Errorf(codes.Internal, "incorrect code: %v", err)
 	}
 
@@ -217,16 +468,16 @@ func (s *AuthService) VerifyForgotPassword(ctx context.Context, req *pbu.VerifyR
 		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
 	}
 
-	token, _, err := utils.CreateToken(s.cfg, &utils.TokenParams{
-		UserID:   result.ID,
-		UserType: result.Type,
-		Email:    result.Email,
-		Duration: time.Minute * 30,
-	})
+	event.UserID = result.ID
+
+	token, payload, err := s.createToken(result, 0, time.Minute*30)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
 	}
 
+	event.Success = true
+	s.recordLoginEvent(ctx, event)
+
 	return &pbu.AuthResponse{
 		Id:          result.ID,
 		FirstName:   result.FirstName,
@@ -236,6 +487,7 @@ func (s *AuthService) VerifyForgotPassword(ctx context.Context, req *pbu.VerifyR
 		Type:        result.Type,
 		CreatedAt:   result.CreatedAt.Format(time.RFC3339),
 		AccessToken: token,
+		Roles:       payload.Roles,
 	}, nil
 }
 
@@ -247,7 +499,11 @@ func (s *AuthService) VerifyToken(ctx context.Context, req *pbu.VerifyTokenReque
 		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
 	}
 
-	hasPermission, err := s.storage.Permission().CheckPermission(payload.UserType, req.Resource, req.Action)
+	if err := checkTokenVersions(s.storage, payload); err != nil {
+		return nil, err
+	}
+
+	hasPermission, err := checkPermission(s.storage, payload, req.Resource, req.Action, req.Attributes)
 	if err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "internal error: %v", err)
 	}
@@ -257,9 +513,138 @@ func (s *AuthService) VerifyToken(ctx context.Context, req *pbu.VerifyTokenReque
 		UserId:        payload.UserID,
 		Email:         payload.Email,
 		UserType:      payload.UserType,
+		Roles:         payload.Roles,
 		IssuedAt:      payload.IssuedAt.Format(time.RFC3339),
 		ExpiredAt:     payload.ExpiredAt.Format(time.RFC3339),
 		HasPermission: hasPermission,
+
+		PermissionVersion: payload.PermissionVersion,
+		Permissions:       payload.Permissions,
+		OrganizationId:    payload.OrganizationID,
+		OrganizationRoles: payload.OrganizationRoles,
+	}, nil
+}
+
+// CheckPermissions verifies the token once and checks all the permissions
+// of the request, results are keyed by "resource:action"
+func (s *AuthService) CheckPermissions(ctx context.Context, req *pbu.CheckPermissionsRequest) (*pbu.CheckPermissionsResponse, error) {
+	payload, err := utils.VerifyToken(s.cfg, req.AccessToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+
+	if err := checkTokenVersions(s.storage, payload); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]bool, len(req.Permissions))
+	for _, p := range req.Permissions {
+		hasPermission, err := checkPermission(s.storage, payload, p.Resource, p.Action, p.Attributes)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		}
+
+		results[p.Resource+":"+p.Action] = hasPermission
+	}
+
+	return &pbu.CheckPermissionsResponse{
+		Payload: &pbu.AuthPayload{
+			Id:        payload.ID.String(),
+			UserId:    payload.UserID,
+			Email:     payload.Email,
+			UserType:  payload.UserType,
+			Roles:     payload.Roles,
+			IssuedAt:  payload.IssuedAt.Format(time.RFC3339),
+			ExpiredAt: payload.ExpiredAt.Format(time.RFC3339),
+
+			PermissionVersion: payload.PermissionVersion,
+			Permissions:       payload.Permissions,
+			OrganizationId:    payload.OrganizationID,
+			OrganizationRoles: payload.OrganizationRoles,
+		},
+		Results: results,
+	}, nil
+}
+
+// SwitchOrganization issues the caller a token acting in the organization,
+// the caller has to be a member of it
+func (s *AuthService) SwitchOrganization(ctx context.Context, req *pbu.SwitchOrganizationRequest) (*pbu.AuthResponse, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reissueToken(ctx, payload, req.OrganizationId)
+}
+
+// RefreshToken issues a new token in place of the bearer token
+func (s *AuthService) RefreshToken(ctx context.Context, req *emptypb.Empty) (*pbu.AuthResponse, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reissueToken(ctx, payload, payload.OrganizationID)
+}
+
+// reissueToken issues a new token to the user of the payload and records
+// the attempt as a token refresh
+func (s *AuthService) reissueToken(ctx context.Context, payload *utils.Payload, organizationID int64) (*pbu.AuthResponse, error) {
+	event := &repo.LoginEvent{
+		UserID: payload.UserID,
+		Email:  payload.Email,
+		Type:   repo.LoginEventTypeTokenRefresh,
+	}
+
+	if organizationID != 0 {
+		roles, err := s.storage.Organization().GetMemberRoles(organizationID, payload.UserID)
+		if err != nil {
+			event.Reason = "internal_error"
+			s.recordLoginEvent(ctx, event)
+			return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		}
+
+		if len(roles) == 0 {
+			event.Reason = "not_organization_member"
+			s.recordLoginEvent(ctx, event)
+			return nil, status.Error(codes.PermissionDenied, "not a member of the organization")
+		}
+	}
+
+	user, err := s.storage.User().Get(payload.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			event.Reason = "user_not_found"
+			s.recordLoginEvent(ctx, event)
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		event.Reason = "internal_error"
+		s.recordLoginEvent(ctx, event)
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	token, payload, err := s.createToken(user, organizationID, time.Hour*24)
+	if err != nil {
+		event.Reason = "internal_error"
+		s.recordLoginEvent(ctx, event)
+		return nil, status.Errorf(codes.Internal, "failed to create token: %v", err)
+	}
+
+	event.Success = true
+	s.recordLoginEvent(ctx, event)
+
+	return &pbu.AuthResponse{
+		Id:                user.ID,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		Email:             user.Email,
+		Username:          user.Username,
+		Type:              user.Type,
+		CreatedAt:         user.CreatedAt.Format(time.RFC3339),
+		AccessToken:       token,
+		Roles:             payload.Roles,
+		OrganizationId:    payload.OrganizationID,
+		OrganizationRoles: payload.OrganizationRoles,
 	}, nil
 }
 
@@ -277,5 +662,14 @@ func (s *UserService) UpdatePassword(ctx context.Context, req *pbu.UpdatePasswor
 		return nil, status.Errorf(codes.Internal, "failed to update password: %v", err)
 	}
 
+	user, err := s.storage.User().Get(req.UserId)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get user")
+	} else {
+		go s.notifier.notify(user, EmailTypePasswordChanged, map[string]string{
+			"time": time.Now().Format(time.RFC1123),
+		})
+	}
+
 	return &emptypb.Empty{}, nil
 }
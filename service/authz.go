@@ -0,0 +1,51 @@
+package service
+
+import (
+	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/authz"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+)
+
+// UserServiceRules are the permissions the methods of UserService require.
+// The reads, the searches and IncrementPopularity stay open to the other
+// services of the app.
+var UserServiceRules = map[string]authz.Rule{
+	"/genproto.UserService/Create":                     {Resource: "users", Action: "create"},
+	"/genproto.UserService/Update":                     {Resource: "users", Action: "update", Target: updateTarget},
+	"/genproto.UserService/UpdatePassword":             {Resource: "users", Action: "update-password", Target: authz.UserID},
+	"/genproto.UserService/Delete":                     {Resource: "users", Action: "delete", Target: authz.ID},
+	"/genproto.UserService/Restore":                    {Resource: "users", Action: "restore", Target: authz.ID},
+	"/genproto.UserService/Purge":                      {Resource: "users", Action: "purge", Target: authz.ID},
+	"/genproto.UserService/ImportUsers":                {Resource: "users", Action: "import", Admin: true},
+	"/genproto.UserService/ExportUsers":                {Resource: "users", Action: "export", Admin: true},
+	"/genproto.UserService/GetNotificationSettings":    {Resource: "users", Action: "get-user-profile", Target: authz.ID},
+	"/genproto.UserService/UpdateNotificationSettings": {Resource: "users", Action: "update", Target: authz.UserID},
+	"/genproto.UserService/GetLoginHistory":            {Resource: "users", Action: "get-user-profile", Target: authz.UserID},
+}
+
+func updateTarget(req interface{}) int64 {
+	if r, ok := req.(*pb.UpdateUserRequest); ok {
+		return r.GetUser().GetId()
+	}
+	return 0
+}
+
+// PermissionChecker checks permissions against the permissions table for the interceptor
+type PermissionChecker struct {
+	storage storage.StorageI
+}
+
+func NewPermissionChecker(strg storage.StorageI) *PermissionChecker {
+	return &PermissionChecker{
+		storage: strg,
+	}
+}
+
+func (c *PermissionChecker) CheckToken(payload *utils.Payload) error {
+	return checkTokenVersions(c.storage, payload)
+}
+
+func (c *PermissionChecker) CheckPermission(payload *utils.Payload, resource, action string, attributes map[string]string) (bool, error) {
+	return checkPermission(c.storage, payload, resource, action, attributes)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/captcha"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const powChallengeDuration = 5 * time.Minute
+
+// captchaRPCs are the rpcs that call verifyCaptcha
+var captchaRPCs = []string{"Register", "Login", "ForgotPassword"}
+
+// CaptchaVerifiers holds the verifier enabled for each public auth rpc
+type CaptchaVerifiers struct {
+	rpcs map[string]captcha.Verifier
+	pow  *captcha.PoWVerifier
+}
+
+func NewCaptchaVerifiers(cfg *config.Config, store captcha.Store) (*CaptchaVerifiers, error) {
+	kinds, err := captcha.ParseRPCs(cfg.CaptchaRPCs, captchaRPCs)
+	if err != nil {
+		return nil, err
+	}
+
+	verifiers := &CaptchaVerifiers{
+		rpcs: make(map[string]captcha.Verifier),
+		pow:  captcha.NewPoWVerifier(store, cfg.PoWDifficulty, powChallengeDuration),
+	}
+
+	for rpc, kind := range kinds {
+		switch kind {
+		case captcha.KindHTTP:
+			if cfg.CaptchaVerifyURL == "" {
+				return nil, fmt.Errorf("captcha verify url is required for %s", rpc)
+			}
+			verifiers.rpcs[rpc] = captcha.NewHTTPVerifier(cfg.CaptchaVerifyURL, cfg.CaptchaSecret)
+		case captcha.KindPoW:
+			verifiers.rpcs[rpc] = verifiers.pow
+		}
+	}
+
+	return verifiers, nil
+}
+
+// verifyCaptcha checks the captcha token if a verifier is enabled for the rpc.
+// It must be called before the rpc does any work.
+func (s *AuthService) verifyCaptcha(ctx context.Context, rpc, token string) error {
+	verifier, ok := s.captcha.rpcs[rpc]
+	if !ok {
+		return nil
+	}
+
+	ip, _ := utils.ClientInfo(ctx, s.cfg.TrustedProxies)
+
+	err := verifier.Verify(ctx, token, ip)
+	if err != nil {
+		s.logger.WithError(err).WithField("rpc", rpc).Info("captcha verification failed")
+		return status.Error(codes.PermissionDenied, "captcha_failed")
+	}
+
+	return nil
+}
+
+func (s *AuthService) GetChallenge(ctx context.Context, req *emptypb.Empty) (*pbu.Challenge, error) {
+	challenge, difficulty, expiresAt, err := s.captcha.pow.Challenge()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create challenge: %v", err)
+	}
+
+	return &pbu.Challenge{
+		Challenge:  challenge,
+		Difficulty: int32(difficulty),
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+	}, nil
+}
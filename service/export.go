@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/userfile"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportChunkSize is the size of the chunks ExportUsers streams
+const exportChunkSize = 64 * 1024
+
+// ExportUsers writes the users matching the filters of params to w in the
+// format and returns their number. Users are read through a cursor and
+// written as they come, so memory does not grow with the table.
+func ExportUsers(strg storage.StorageI, params *repo.GetUsersParams, w io.Writer, format string) (int, error) {
+	writer, err := userfile.NewWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = strg.User().Export(params, func(user *repo.User) error {
+		count++
+		return writer.Write(&userfile.User{
+			ID:              user.ID,
+			FirstName:       user.FirstName,
+			LastName:        user.LastName,
+			Email:           user.Email,
+			PhoneNumber:     user.PhoneNumber,
+			Gender:          user.Gender,
+			Username:        user.Username,
+			ProfileImageUrl: user.ProfileImageUrl,
+			Type:            user.Type,
+			Verified:        user.Verified,
+			CreatedAt:       user.CreatedAt.Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, writer.Close()
+}
+
+// ExportUsers streams the exported file in chunks
+func (s *UserService) ExportUsers(req *pb.ExportUsersRequest, stream pb.UserService_ExportUsersServer) error {
+	filter := req.Filter
+	if filter == nil {
+		filter = &pb.GetAllUsersRequest{}
+	}
+
+	params, err := usersFilterParams(filter)
+	if err != nil {
+		return err
+	}
+
+	format := req.Format
+	if format != userfile.FormatCSV && format != userfile.FormatJSONL && format != userfile.FormatParquet {
+		return status.Errorf(codes.InvalidArgument, "format must be %s, %s or %s", userfile.FormatCSV, userfile.FormatJSONL, userfile.FormatParquet)
+	}
+
+	w := bufio.NewWriterSize(chunkWriter(func(data []byte) error {
+		return stream.Send(&pb.ExportUsersResponse{Data: data})
+	}), exportChunkSize)
+
+	if _, err := ExportUsers(s.storage, params, w, format); err != nil {
+		s.logger.WithError(err).Error("failed to export users")
+		return status.Errorf(codes.Internal, "failed to export users: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return status.Errorf(codes.Internal, "failed to export users: %v", err)
+	}
+
+	return nil
+}
+
+// chunkWriter sends every write as a chunk
+type chunkWriter func(data []byte) error
+
+func (c chunkWriter) Write(data []byte) (int, error) {
+	// the data may be reused by the caller after Write returns
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+
+	if err := c(chunk); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
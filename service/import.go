@@ -0,0 +1,366 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/userfile"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/username"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultImportBatchSize = 500
+	maxImportBatchSize     = 5000
+)
+
+type UserImportOptions struct {
+	// OnDuplicate is repo.ImportSkipDuplicates, repo.ImportUpdateDuplicates
+	// or repo.ImportReplaceDuplicates, skip by default
+	OnDuplicate string
+	DryRun      bool
+	BatchSize   int
+}
+
+// UserImportError is a row that was not imported, rows are counted from 1
+type UserImportError struct {
+	Row     int
+	Email   string
+	Message string
+}
+
+type UserImportReport struct {
+	Created int
+	Updated int
+	Skipped int
+	Failed  int
+	Errors  []*UserImportError
+	DryRun  bool
+}
+
+func (r *UserImportReport) String() string {
+	var b strings.Builder
+
+	if r.DryRun {
+		b.WriteString("Dry run, nothing was written\n")
+	}
+
+	fmt.Fprintf(&b, "created: %d, updated: %d, skipped: %d, failed: %d\n", r.Created, r.Updated, r.Skipped, r.Failed)
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "row %d (%s): %s\n", e.Row, e.Email, e.Message)
+	}
+
+	return b.String()
+}
+
+// UserImport validates the rows it is given and writes the valid ones in
+// batches, every batch in its own transaction
+type UserImport struct {
+	storage   storage.StorageI
+	usernames *username.Rules
+	options   UserImportOptions
+	report    UserImportReport
+
+	row       int
+	emails    map[string]bool
+	roles     map[string]bool
+	batch     []*repo.User
+	batchRows []int
+}
+
+func NewUserImport(strg storage.StorageI, usernames *username.Rules, options UserImportOptions) (*UserImport, error) {
+	if options.OnDuplicate == "" {
+		options.OnDuplicate = repo.ImportSkipDuplicates
+	}
+
+	switch options.OnDuplicate {
+	case repo.ImportSkipDuplicates, repo.ImportUpdateDuplicates, repo.ImportReplaceDuplicates:
+	default:
+		return nil, fmt.Errorf("duplicates can be %q, %q or %q, not %q",
+			repo.ImportSkipDuplicates, repo.ImportUpdateDuplicates, repo.ImportReplaceDuplicates, options.OnDuplicate)
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultImportBatchSize
+	} else if options.BatchSize > maxImportBatchSize {
+		options.BatchSize = maxImportBatchSize
+	}
+
+	return &UserImport{
+		storage:   strg,
+		usernames: usernames,
+		options:   options,
+		report: UserImportReport{
+			Errors: make([]*UserImportError, 0),
+			DryRun: options.DryRun,
+		},
+		emails: make(map[string]bool),
+	}, nil
+}
+
+// Add imports the row, invalid rows are reported. The error is returned
+// only when the database fails.
+func (i *UserImport) Add(row *userfile.Row) error {
+	i.row++
+
+	if i.roles == nil {
+		roles, err := roleNames(i.storage)
+		if err != nil {
+			return err
+		}
+		i.roles = roles
+	}
+
+	user, err := validateImportRow(row, i.roles, i.usernames)
+	if err == nil && i.emails[strings.ToLower(user.Email)] {
+		err = fmt.Errorf("email appears in an earlier row")
+	}
+
+	if err != nil {
+		i.fail(i.row, row.Email, err)
+		return nil
+	}
+
+	i.emails[strings.ToLower(user.Email)] = true
+	i.batch = append(i.batch, user)
+	i.batchRows = append(i.batchRows, i.row)
+
+	if len(i.batch) >= i.options.BatchSize {
+		return i.flush()
+	}
+
+	return nil
+}
+
+// Finish writes the last batch and returns the report
+func (i *UserImport) Finish() (*UserImportReport, error) {
+	if err := i.flush(); err != nil {
+		return nil, err
+	}
+
+	return &i.report, nil
+}
+
+func (i *UserImport) flush() error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+
+	results, err := i.storage.User().ImportUsers(i.batch, i.options.OnDuplicate, i.options.DryRun)
+	if err != nil {
+		return err
+	}
+
+	for n, result := range results {
+		switch {
+		case result.Err != nil:
+			i.fail(i.batchRows[n], i.batch[n].Email, result.Err)
+		case result.Outcome == repo.ImportCreated:
+			i.report.Created++
+		case result.Outcome == repo.ImportUpdated:
+			i.report.Updated++
+		case result.Outcome == repo.ImportSkipped:
+			i.report.Skipped++
+		}
+	}
+
+	i.batch = nil
+	i.batchRows = nil
+
+	return nil
+}
+
+func (i *UserImport) fail(row int, email string, err error) {
+	i.report.Failed++
+	i.report.Errors = append(i.report.Errors, &UserImportError{
+		Row:     row,
+		Email:   email,
+		Message: err.Error(),
+	})
+}
+
+// ImportUsers imports the rows streamed by the client and replies with the
+// report once the stream is closed
+func (s *UserService) ImportUsers(stream pb.UserService_ImportUsersServer) error {
+	var userImport *UserImport
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if userImport == nil {
+			options := req.GetOptions()
+			userImport, err = NewUserImport(s.storage, s.usernames, UserImportOptions{
+				OnDuplicate: options.GetOnDuplicate(),
+				DryRun:      options.GetDryRun(),
+				BatchSize:   int(options.GetBatchSize()),
+			})
+			if err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+
+		for _, row := range req.Rows {
+			err := userImport.Add(&userfile.Row{
+				FirstName:    row.FirstName,
+				LastName:     row.LastName,
+				Email:        row.Email,
+				PhoneNumber:  row.PhoneNumber,
+				Gender:       row.Gender,
+				Username:     row.Username,
+				Password:     row.Password,
+				PasswordHash: row.PasswordHash,
+				Type:         row.Type,
+			})
+			if err != nil {
+				s.logger.WithError(err).Error("failed to import users")
+				return status.Errorf(codes.Internal, "failed to import users: %v", err)
+			}
+		}
+	}
+
+	if userImport == nil {
+		return status.Error(codes.InvalidArgument, "no rows to import")
+	}
+
+	report, err := userImport.Finish()
+	if err != nil {
+		s.logger.WithError(err).Error("failed to import users")
+		return status.Errorf(codes.Internal, "failed to import users: %v", err)
+	}
+
+	response := pb.ImportUsersResponse{
+		Created: int32(report.Created),
+		Updated: int32(report.Updated),
+		Skipped: int32(report.Skipped),
+		Failed:  int32(report.Failed),
+		Errors:  make([]*pb.ImportUserError, 0, len(report.Errors)),
+		DryRun:  report.DryRun,
+	}
+
+	for _, e := range report.Errors {
+		response.Errors = append(response.Errors, &pb.ImportUserError{
+			Row:     int32(e.Row),
+			Email:   e.Email,
+			Message: e.Message,
+		})
+	}
+
+	return stream.SendAndClose(&response)
+}
+
+// roleNames returns the names of every role
+func roleNames(strg storage.StorageI) (map[string]bool, error) {
+	const pageSize = 100
+
+	names := make(map[string]bool)
+	for page := int32(1); ; page++ {
+		result, err := strg.Role().GetAll(&repo.GetRolesParams{
+			Limit: pageSize,
+			Page:  page,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, role := range result.Roles {
+			names[role.Name] = true
+		}
+
+		if len(result.Roles) < pageSize {
+			return names, nil
+		}
+	}
+}
+
+// validateImportRow checks the row against the constraints of the users
+// table, roles are the names the type can take and usernames the rules the
+// username has to follow. It returns the user of the row with the password
+// hashed.
+func validateImportRow(row *userfile.Row, roles map[string]bool, usernames *username.Rules) (*repo.User, error) {
+	user := repo.User{
+		FirstName:   strings.TrimSpace(row.FirstName),
+		LastName:    strings.TrimSpace(row.LastName),
+		Email:       strings.TrimSpace(row.Email),
+		PhoneNumber: strings.TrimSpace(row.PhoneNumber),
+		Gender:      strings.ToLower(strings.TrimSpace(row.Gender)),
+		Username:    strings.TrimSpace(row.Username),
+		Type:        strings.TrimSpace(row.Type),
+	}
+
+	if user.FirstName == "" || user.LastName == "" {
+		return nil, fmt.Errorf("first_name and last_name are required")
+	}
+
+	if len(user.FirstName) > 30 || len(user.LastName) > 30 {
+		return nil, fmt.Errorf("first_name and last_name can have at most 30 characters")
+	}
+
+	if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+		return nil, fmt.Errorf("invalid email %q", user.Email)
+	}
+
+	if len(user.Email) > 50 {
+		return nil, fmt.Errorf("email can have at most 50 characters")
+	}
+
+	if len(user.PhoneNumber) > 20 {
+		return nil, fmt.Errorf("phone_number can have at most 20 characters")
+	}
+
+	if user.Gender != "" && user.Gender != "male" && user.Gender != "female" {
+		return nil, fmt.Errorf("gender must be male or female")
+	}
+
+	if user.Username != "" {
+		if err := usernames.Validate(user.Username); err != nil {
+			return nil, err
+		}
+
+		if usernames.IsReserved(user.Username) {
+			return nil, fmt.Errorf("username %q is reserved", user.Username)
+		}
+	}
+
+	if user.Type == "" {
+		user.Type = repo.UserTypeUser
+	}
+
+	if !roles[user.Type] {
+		return nil, fmt.Errorf("type %q is not a role", user.Type)
+	}
+
+	switch {
+	case row.Password != "" && row.PasswordHash != "":
+		return nil, fmt.Errorf("only one of password and password_hash can be set")
+	case row.PasswordHash != "":
+		if _, err := bcrypt.Cost([]byte(row.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("password_hash is not a bcrypt hash")
+		}
+		user.Password = row.PasswordHash
+	case row.Password != "":
+		hashedPassword, err := utils.HashPassword(row.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = hashedPassword
+	default:
+		return nil, fmt.Errorf("password or password_hash is required")
+	}
+
+	return &user, nil
+}
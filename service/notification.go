@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	pbn "github.com/ibrat-muslim/blog_app_user_service/genproto/notification_service"
+	grpcPkg "github.com/ibrat-muslim/blog_app_user_service/pkg/grpc_client"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+)
+
+// The email change, two-factor and suspension emails have no flow in this
+// service yet, they are reserved for the flows that will send them.
+const (
+	EmailTypeWelcome              = "welcome_email"
+	EmailTypeNewLogin             = "new_login_email"
+	EmailTypePasswordChanged      = "password_changed_email"
+	EmailTypeEmailChangeRequested = "email_change_requested_email"
+	EmailTypeTwoFactorDisabled    = "two_factor_disabled_email"
+	EmailTypeAccountSuspended     = "account_suspended_email"
+	EmailTypeAccountDeleted       = "account_deleted_email"
+
+	EmailTypeOrganizationInvitation = "organization_invitation_email"
+)
+
+const (
+	KnownDeviceKey      = "known_device_"
+	knownDeviceDuration = 180 * 24 * time.Hour
+)
+
+var emailSubjects = map[string]string{
+	EmailTypeWelcome:              "Welcome to the blog",
+	EmailTypeNewLogin:             "New login to your account",
+	EmailTypePasswordChanged:      "Your password was changed",
+	EmailTypeEmailChangeRequested: "Email change requested",
+	EmailTypeTwoFactorDisabled:    "Two-factor authentication disabled",
+	EmailTypeAccountSuspended:     "Your account has been suspended",
+	EmailTypeAccountDeleted:       "Your account has been deleted",
+
+	EmailTypeOrganizationInvitation: "You are invited to an organization",
+}
+
+// optionalEmailTypes are the emails a user may opt out of.
+// Every other type is security-critical and is always sent.
+var optionalEmailTypes = map[string]bool{
+	EmailTypeWelcome:  true,
+	EmailTypeNewLogin: true,
+}
+
+type notifier struct {
+	storage    storage.StorageI
+	inMemory   storage.InMemoryStorageI
+	grpcClient grpcPkg.GrpcClientI
+	logger     *logrus.Logger
+}
+
+// notify emails the user unless they opted out of the email type.
+// It is meant to be run in a goroutine, so errors are only logged.
+func (n *notifier) notify(user *repo.User, emailType string, body map[string]string) {
+	if optionalEmailTypes[emailType] {
+		disabled, err := n.storage.Notification().GetDisabledTypes(user.ID)
+		if err != nil {
+			n.logger.WithError(err).Error("failed to get disabled notifications")
+			return
+		}
+
+		for _, t := range disabled {
+			if t == emailType {
+				return
+			}
+		}
+	}
+
+	if body == nil {
+		body = make(map[string]string)
+	}
+	body["first_name"] = user.FirstName
+
+	_, err := n.grpcClient.NotificationService().SendEmail(context.Background(), &pbn.SendEmailRequest{
+		To:      user.Email,
+		Type:    emailType,
+		Subject: emailSubjects[emailType],
+		Body:    body,
+	})
+	if err != nil {
+		n.logger.WithError(err).WithField("type", emailType).Error("failed to send email")
+	}
+}
+
+// rememberDevice marks the client as known for the user and reports
+// whether it was seen before.
+func (n *notifier) rememberDevice(userID int64, ip, userAgent string) bool {
+	hash := sha256.Sum256([]byte(ip + "|" + userAgent))
+	key := fmt.Sprintf("%s%d_%s", KnownDeviceKey, userID, hex.EncodeToString(hash[:8]))
+
+	_, err := n.inMemory.Get(key)
+	known := err == nil
+
+	err = n.inMemory.Set(key, time.Now().Format(time.RFC3339), knownDeviceDuration)
+	if err != nil {
+		n.logger.WithError(err).Error("failed to remember device")
+	}
+
+	return known
+}
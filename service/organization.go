@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pbn "github.com/ibrat-muslim/blog_app_user_service/genproto/notification_service"
+	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	grpcPkg "github.com/ibrat-muslim/blog_app_user_service/pkg/grpc_client"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	organizationResource = "organizations"
+
+	invitationDuration = 7 * 24 * time.Hour
+)
+
+type OrganizationService struct {
+	pbu.UnimplementedOrganizationServiceServer
+	storage    storage.StorageI
+	grpcClient grpcPkg.GrpcClientI
+	roles      map[string]bool
+	cfg        *config.Config
+	logger     *logrus.Logger
+}
+
+func NewOrganizationService(strg storage.StorageI, grpcClient grpcPkg.GrpcClientI, cfg *config.Config, logger *logrus.Logger) *OrganizationService {
+	return &OrganizationService{
+		storage:    strg,
+		grpcClient: grpcClient,
+		roles:      organizationRoles(cfg.OrganizationRoles),
+		cfg:        cfg,
+		logger:     logger,
+	}
+}
+
+// organizationRoles returns the roles members can get in an organization.
+// The global roles are never among them, a superadmin role granted within
+// an organization would be a superadmin.
+func organizationRoles(list string) map[string]bool {
+	roles := map[string]bool{repo.OrganizationOwner: true}
+
+	for _, role := range strings.Split(list, ",") {
+		role = strings.TrimSpace(role)
+		if role == "" || role == repo.UserTypeSuperAdmin || role == repo.UserTypeUser {
+			continue
+		}
+		roles[role] = true
+	}
+
+	return roles
+}
+
+// authorize verifies the bearer token and checks the organizations
+// permission of the caller on the organization
+func (s *OrganizationService) authorize(ctx context.Context, organizationID int64, action string) (*utils.Payload, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	hasPermission, err := checkPermission(s.storage, payload, organizationResource, action, map[string]string{
+		"id": strconv.FormatInt(organizationID, 10),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to check permission")
+		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	if !hasPermission {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return payload, nil
+}
+
+// Create creates an organization owned by the caller
+func (s *OrganizationService) Create(ctx context.Context, req *pbu.Organization) (*pbu.Organization, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" || req.Slug == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and slug are required")
+	}
+
+	organization, err := s.storage.Organization().Create(&repo.Organization{
+		Name: req.Name,
+		Slug: req.Slug,
+	}, payload.UserID)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to create organization")
+		return nil, status.Errorf(codes.Internal, "failed to create an organization: %v", err)
+	}
+
+	return parseOrganizationModel(organization), nil
+}
+
+func (s *OrganizationService) Get(ctx context.Context, req *pbu.GetOrganizationRequest) (*pbu.Organization, error) {
+	if _, err := authenticate(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	organization, err := s.storage.Organization().Get(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get organization")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get an organization: %v", err)
+	}
+
+	return parseOrganizationModel(organization), nil
+}
+
+// GetAll lists the organizations of the caller, superadmins can list
+// anyone's or all of them
+func (s *OrganizationService) GetAll(ctx context.Context, req *pbu.GetAllOrganizationsRequest) (*pbu.GetAllOrganizationsResponse, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := req.UserId
+	if !payload.HasRole(repo.UserTypeSuperAdmin) {
+		if userID != 0 && userID != payload.UserID {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+		userID = payload.UserID
+	}
+
+	result, err := s.storage.Organization().GetAll(&repo.GetOrganizationsParams{
+		Limit:  req.Limit,
+		Page:   req.Page,
+		Search: req.Search,
+		UserID: userID,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get all organizations")
+		return nil, status.Errorf(codes.Internal, "failed to get all organizations: %v", err)
+	}
+
+	response := pbu.GetAllOrganizationsResponse{
+		Organizations: make([]*pbu.Organization, 0),
+		Count:         result.Count,
+	}
+
+	for _, organization := range result.Organizations {
+		response.Organizations = append(response.Organizations, parseOrganizationModel(organization))
+	}
+
+	return &response, nil
+}
+
+func (s *OrganizationService) Update(ctx context.Context, req *pbu.Organization) (*pbu.Organization, error) {
+	if _, err := s.authorize(ctx, req.Id, "update"); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" || req.Slug == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and slug are required")
+	}
+
+	organization, err := s.storage.Organization().Update(&repo.Organization{
+		ID:   req.Id,
+		Name: req.Name,
+		Slug: req.Slug,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to update organization")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update an organization: %v", err)
+	}
+
+	return parseOrganizationModel(organization), nil
+}
+
+func (s *OrganizationService) Delete(ctx context.Context, req *pbu.GetOrganizationRequest) (*emptypb.Empty, error) {
+	if _, err := s.authorize(ctx, req.Id, "delete"); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.Organization().Delete(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to delete organization")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to delete an organization: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *OrganizationService) AddMember(ctx context.Context, req *pbu.OrganizationMemberRequest) (*pbu.OrganizationMembersResponse, error) {
+	if _, err := s.authorize(ctx, req.OrganizationId, "manage-members"); err != nil {
+		return nil, err
+	}
+
+	if req.Role == "" {
+		return nil, status.Error(codes.InvalidArgument, "role is required")
+	}
+
+	if !s.roles[req.Role] {
+		return nil, status.Error(codes.InvalidArgument, "role is not an organization role")
+	}
+
+	err := s.storage.Organization().AddMember(req.OrganizationId, req.UserId, req.Role)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to add organization member")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to add a member: %v", err)
+	}
+
+	return s.members(req.OrganizationId)
+}
+
+func (s *OrganizationService) RemoveMember(ctx context.Context, req *pbu.OrganizationMemberRequest) (*pbu.OrganizationMembersResponse, error) {
+	if _, err := s.authorize(ctx, req.OrganizationId, "manage-members"); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.Organization().RemoveMember(req.OrganizationId, req.UserId, req.Role)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to remove organization member")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if errors.Is(err, repo.ErrLastOwner) {
+			return nil, status.Error(codes.FailedPrecondition, "last_owner")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to remove a member: %v", err)
+	}
+
+	return s.members(req.OrganizationId)
+}
+
+func (s *OrganizationService) GetMembers(ctx context.Context, req *pbu.GetOrganizationRequest) (*pbu.OrganizationMembersResponse, error) {
+	if _, err := s.authorize(ctx, req.Id, "get-members"); err != nil {
+		return nil, err
+	}
+
+	return s.members(req.Id)
+}
+
+// Invite emails an invitation to join the organization with the role
+func (s *OrganizationService) Invite(ctx context.Context, req *pbu.InviteRequest) (*pbu.Invitation, error) {
+	payload, err := s.authorize(ctx, req.OrganizationId, "manage-members")
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Email == "" || req.Role == "" {
+		return nil, status.Error(codes.InvalidArgument, "email and role are required")
+	}
+
+	if !s.roles[req.Role] {
+		return nil, status.Error(codes.InvalidArgument, "role is not an organization role")
+	}
+
+	organization, err := s.storage.Organization().Get(req.OrganizationId)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get organization")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get an organization: %v", err)
+	}
+
+	token, err := invitationToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate token: %v", err)
+	}
+
+	invitation, err := s.storage.Organization().CreateInvitation(&repo.OrganizationInvitation{
+		OrganizationID: req.OrganizationId,
+		Email:          strings.ToLower(req.Email),
+		Role:           req.Role,
+		Token:          token,
+		InvitedBy:      payload.UserID,
+		ExpiresAt:      time.Now().Add(invitationDuration),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to create invitation")
+		return nil, status.Errorf(codes.Internal, "failed to create an invitation: %v", err)
+	}
+
+	go func() {
+		_, err := s.grpcClient.NotificationService().SendEmail(context.Background(), &pbn.SendEmailRequest{
+			To:      invitation.Email,
+			Type:    EmailTypeOrganizationInvitation,
+			Subject: emailSubjects[EmailTypeOrganizationInvitation],
+			Body: map[string]string{
+				"organization": organization.Name,
+				"role":         invitation.Role,
+				"token":        invitation.Token,
+			},
+		})
+		if err != nil {
+			s.logger.WithError(err).Error("failed to send invitation email")
+		}
+	}()
+
+	return parseInvitationModel(invitation), nil
+}
+
+// AcceptInvitation adds the caller to the organization of the invitation,
+// which has to be addressed to the caller's email
+func (s *OrganizationService) AcceptInvitation(ctx context.Context, req *pbu.AcceptInvitationRequest) (*pbu.OrganizationMembersResponse, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := s.storage.Organization().GetInvitation(req.Token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "invitation not found")
+		}
+		s.logger.WithError(err).Error("failed to get invitation")
+		return nil, status.Errorf(codes.Internal, "failed to get an invitation: %v", err)
+	}
+
+	if !strings.EqualFold(invitation.Email, payload.Email) {
+		return nil, status.Error(codes.PermissionDenied, "invitation is addressed to another email")
+	}
+
+	if invitation.AcceptedAt != nil {
+		return nil, status.Error(codes.FailedPrecondition, "invitation_accepted")
+	}
+
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, status.Error(codes.FailedPrecondition, "invitation_expired")
+	}
+
+	// invitations created before the role was dropped from the organization roles
+	if !s.roles[invitation.Role] {
+		return nil, status.Error(codes.FailedPrecondition, "invitation_role_invalid")
+	}
+
+	err = s.storage.Organization().AcceptInvitation(invitation.ID, payload.UserID)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to accept invitation")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.FailedPrecondition, "invitation_accepted")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to accept an invitation: %v", err)
+	}
+
+	return s.members(invitation.OrganizationID)
+}
+
+func (s *OrganizationService) members(organizationID int64) (*pbu.OrganizationMembersResponse, error) {
+	members, err := s.storage.Organization().GetMembers(organizationID)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get organization members")
+		return nil, status.Errorf(codes.Internal, "failed to get members: %v", err)
+	}
+
+	response := pbu.OrganizationMembersResponse{
+		OrganizationId: organizationID,
+		Members:        make([]*pbu.OrganizationMember, 0, len(members)),
+	}
+
+	for _, m := range members {
+		response.Members = append(response.Members, &pbu.OrganizationMember{
+			UserId: m.UserID,
+			Roles:  m.Roles,
+		})
+	}
+
+	return &response, nil
+}
+
+func invitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func parseOrganizationModel(organization *repo.Organization) *pbu.Organization {
+	return &pbu.Organization{
+		Id:        organization.ID,
+		Name:      organization.Name,
+		Slug:      organization.Slug,
+		CreatedAt: organization.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func parseInvitationModel(invitation *repo.OrganizationInvitation) *pbu.Invitation {
+	return &pbu.Invitation{
+		Id:             invitation.ID,
+		OrganizationId: invitation.OrganizationID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		ExpiresAt:      invitation.ExpiresAt.Format(time.RFC3339),
+		CreatedAt:      invitation.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,506 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/authz"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Values of config.TokenPermissions
+const (
+	TokenPermissionsVersion = "version"
+	TokenPermissionsList    = "list"
+)
+
+type PermissionService struct {
+	pbu.UnimplementedPermissionServiceServer
+	storage storage.StorageI
+	cache   *storage.PermissionCache
+	cfg     *config.Config
+	logger  *logrus.Logger
+}
+
+func NewPermissionService(strg storage.StorageI, cache *storage.PermissionCache, cfg *config.Config, logger *logrus.Logger) *PermissionService {
+	return &PermissionService{
+		storage: strg,
+		cache:   cache,
+		cfg:     cfg,
+		logger:  logger,
+	}
+}
+
+// authenticate returns the payload the interceptor put into the
+// context, or verifies the bearer token of the request itself. Tokens the
+// interceptor didn't check are rejected once their versions are stale.
+func authenticate(ctx context.Context, cfg *config.Config, strg storage.StorageI) (*utils.Payload, error) {
+	if payload, ok := authz.PayloadFromContext(ctx); ok {
+		return payload, nil
+	}
+
+	token := utils.BearerToken(ctx)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing access token")
+	}
+
+	payload, err := utils.VerifyToken(cfg, token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+
+	if err := checkTokenVersions(strg, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// requireSuperAdmin verifies the bearer token of the request
+// and allows only superadmins through.
+func requireSuperAdmin(ctx context.Context, cfg *config.Config, strg storage.StorageI) (*utils.Payload, error) {
+	payload, err := authenticate(ctx, cfg, strg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !payload.HasRole(repo.UserTypeSuperAdmin) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return payload, nil
+}
+
+// checkTokenVersions rejects tokens issued before the roles of the user
+// or, if the token carries its version, the permission set changed
+func checkTokenVersions(strg storage.StorageI, payload *utils.Payload) error {
+	rolesVersion, err := strg.Role().GetRolesVersion(payload.UserID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	if payload.RolesVersion != rolesVersion {
+		return status.Error(codes.Unauthenticated, "stale_permissions")
+	}
+
+	if payload.PermissionVersion == 0 {
+		return nil
+	}
+
+	version, err := strg.Permission().GetVersion()
+	if err != nil {
+		return status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+
+	if payload.PermissionVersion != version {
+		return status.Error(codes.Unauthenticated, "stale_permissions")
+	}
+
+	return nil
+}
+
+// permissionRoles returns the roles of the payload together with the roles
+// of the user in the organization, if any
+func permissionRoles(strg storage.StorageI, payload *utils.Payload, organizationID int64) ([]string, error) {
+	if organizationID == 0 {
+		return payload.Roles, nil
+	}
+
+	organizationRoles, err := strg.Organization().GetMemberRoles(organizationID, payload.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(payload.Roles)+len(organizationRoles))
+	roles = append(roles, payload.Roles...)
+	for _, role := range organizationRoles {
+		// memberships given global roles before they were refused
+		if role == repo.UserTypeSuperAdmin || role == repo.UserTypeUser {
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// resourceOrganization returns the organization a resource belongs to: the
+// id segment of "organizations/<id>/..." paths, or the id attribute of the
+// "organizations" resource itself. Other resources belong to none.
+func resourceOrganization(resource string, attributes map[string]string) int64 {
+	segments := strings.Split(resource, "/")
+	if segments[0] != organizationResource {
+		return 0
+	}
+
+	id := attributes["id"]
+	if len(segments) > 1 {
+		id = segments[1]
+	}
+
+	organizationID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return organizationID
+}
+
+// checkPermission checks if the roles of the payload, and of the user in the
+// organization the resource belongs to, have the permission on the resource
+// described by attributes. A permission without a condition holds for every
+// resource, a deny that holds overrides every allow.
+func checkPermission(strg storage.StorageI, payload *utils.Payload, resource, action string, attributes map[string]string) (bool, error) {
+	roles, err := permissionRoles(strg, payload, resourceOrganization(resource, attributes))
+	if err != nil {
+		return false, err
+	}
+
+	permissions, err := strg.Permission().GetMatching(roles, resource, action)
+	if err != nil {
+		return false, err
+	}
+
+	subject := policy.Subject{
+		ID:    payload.UserID,
+		Email: payload.Email,
+	}
+	now := time.Now()
+
+	allowed := false
+	for _, p := range permissions {
+		ok, err := policy.Evaluate(p.Condition, subject, attributes, now)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if p.Effect == repo.PermissionDeny {
+			return false, nil
+		}
+		allowed = true
+	}
+
+	return allowed, nil
+}
+
+// rolePermissions returns the allow and the deny permissions of the roles
+func rolePermissions(strg storage.StorageI, roles []string) (allows, denies []*repo.Permission, err error) {
+	for _, role := range roles {
+		result, err := strg.Permission().GetAll(&repo.GetPermissionsParams{
+			UserType: role,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range result.Permissions {
+			if p.Effect == repo.PermissionDeny {
+				denies = append(denies, p)
+				continue
+			}
+			allows = append(allows, p)
+		}
+	}
+
+	return allows, denies, nil
+}
+
+// effectivePermissions returns the allow permissions of the roles that are
+// not overridden by an unconditional deny, followed by the denies that
+// narrow them, so "posts:*" isn't read as access to a denied "posts:delete"
+func effectivePermissions(strg storage.StorageI, roles []string) ([]*repo.Permission, error) {
+	allows, denies, err := rolePermissions(strg, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]*repo.Permission, 0, len(allows))
+	for _, allow := range allows {
+		denied := false
+		for _, deny := range denies {
+			if deny.Condition == "" && policy.MatchAction(deny.Action, allow.Action) && policy.MatchResource(deny.Resource, allow.Resource) {
+				denied = true
+				break
+			}
+		}
+
+		if !denied {
+			permissions = append(permissions, allow)
+		}
+	}
+
+	allows = permissions
+	for _, deny := range denies {
+		for _, allow := range allows {
+			if policy.OverlapAction(deny.Action, allow.Action) && policy.OverlapResource(deny.Resource, allow.Resource) {
+				permissions = append(permissions, deny)
+				break
+			}
+		}
+	}
+
+	return permissions, nil
+}
+
+// compactPermissions lists the permissions of the global roles a token can
+// carry as "resource:action". Only unconditional allows that no deny,
+// conditional or not, touches are listed, the rest can only be checked with
+// the resource at hand through CheckPermissions. Organization roles hold only
+// on the resources of their organization, so they are left out too.
+func compactPermissions(strg storage.StorageI, roles []string) ([]string, error) {
+	allows, denies, err := rolePermissions(strg, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(allows))
+	seen := make(map[string]bool, len(allows))
+
+	for _, p := range allows {
+		key := p.Resource + ":" + p.Action
+		if p.Condition != "" || seen[key] || overlapsDeny(p, denies) {
+			continue
+		}
+
+		seen[key] = true
+		result = append(result, key)
+	}
+
+	return result, nil
+}
+
+func overlapsDeny(allow *repo.Permission, denies []*repo.Permission) bool {
+	for _, deny := range denies {
+		if policy.OverlapAction(deny.Action, allow.Action) && policy.OverlapResource(deny.Resource, allow.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePermission(userType, resource, action, condition, effect string) error {
+	if userType == "" || resource == "" || action == "" {
+		return status.Error(codes.InvalidArgument, "user type, resource and action are required")
+	}
+
+	if effect != repo.PermissionAllow && effect != repo.PermissionDeny {
+		return status.Error(codes.InvalidArgument, "effect must be allow or deny")
+	}
+
+	if err := policy.Validate(condition); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil
+}
+
+func (s *PermissionService) Create(ctx context.Context, req *pbu.Permission) (*pbu.Permission, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	if err := validatePermission(req.UserType, req.Resource, req.Action, req.Condition, permissionEffect(req.Effect)); err != nil {
+		return nil, err
+	}
+
+	permission, err := s.storage.Permission().Create(&repo.Permission{
+		UserType:  req.UserType,
+		Resource:  req.Resource,
+		Action:    req.Action,
+		Condition: req.Condition,
+		Effect:    permissionEffect(req.Effect),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to create permission")
+		return nil, status.Errorf(codes.Internal, "failed to create a permission: %v", err)
+	}
+
+	return parsePermissionModel(permission), nil
+}
+
+func (s *PermissionService) Delete(ctx context.Context, req *pbu.GetPermissionRequest) (*emptypb.Empty, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.Permission().Delete(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to delete permission")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to delete a permission: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *PermissionService) GetAll(ctx context.Context, req *pbu.GetAllPermissionsRequest) (*pbu.GetAllPermissionsResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	result, err := s.storage.Permission().GetAll(&repo.GetPermissionsParams{
+		UserType: req.UserType,
+		Resource: req.Resource,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get all permissions")
+		return nil, status.Errorf(codes.Internal, "failed to get all permissions: %v", err)
+	}
+
+	return parsePermissionsResult(result), nil
+}
+
+// Replace makes the given permissions the only permissions of the user type
+func (s *PermissionService) Replace(ctx context.Context, req *pbu.ReplacePermissionsRequest) (*pbu.GetAllPermissionsResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	permissions := make([]*repo.Permission, 0, len(req.Permissions))
+	for _, p := range req.Permissions {
+		if err := validatePermission(req.UserType, p.Resource, p.Action, p.Condition, permissionEffect(p.Effect)); err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, &repo.Permission{
+			UserType:  req.UserType,
+			Resource:  p.Resource,
+			Action:    p.Action,
+			Condition: p.Condition,
+			Effect:    permissionEffect(p.Effect),
+		})
+	}
+
+	err := s.storage.Permission().Replace(req.UserType, permissions)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to replace permissions")
+		return nil, status.Errorf(codes.Internal, "failed to replace permissions: %v", err)
+	}
+
+	result, err := s.storage.Permission().GetAll(&repo.GetPermissionsParams{
+		UserType: req.UserType,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get all permissions")
+		return nil, status.Errorf(codes.Internal, "failed to get all permissions: %v", err)
+	}
+
+	return parsePermissionsResult(result), nil
+}
+
+func (s *PermissionService) GetCacheStats(ctx context.Context, req *emptypb.Empty) (*pbu.PermissionCacheStats, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	stats := s.cache.Stats()
+
+	return &pbu.PermissionCacheStats{
+		Hits:   stats.Hits,
+		Misses: stats.Misses,
+		Loaded: stats.Loaded,
+		Size:   int32(stats.Size),
+	}, nil
+}
+
+// permissionEffect defaults an empty effect to allow
+func permissionEffect(effect string) string {
+	if effect == "" {
+		return repo.PermissionAllow
+	}
+
+	return effect
+}
+
+// GetEffectivePermissions lists the permissions a user ends up with through
+// their roles. Users can list their own, superadmins anyone's.
+func (s *PermissionService) GetEffectivePermissions(ctx context.Context, req *pbu.GetEffectivePermissionsRequest) (*pbu.GetEffectivePermissionsResponse, error) {
+	payload, err := authenticate(ctx, s.cfg, s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := permissionRoles(s.storage, payload, payload.OrganizationID)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get organization roles")
+		return nil, status.Errorf(codes.Internal, "failed to get organization roles: %v", err)
+	}
+
+	if req.UserId != 0 && req.UserId != payload.UserID {
+		if !payload.HasRole(repo.UserTypeSuperAdmin) {
+			return nil, status.Error(codes.PermissionDenied, "permission denied")
+		}
+
+		roles, err = s.storage.Role().GetUserRoles(req.UserId)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to get user roles")
+			return nil, status.Errorf(codes.Internal, "failed to get user roles: %v", err)
+		}
+	}
+
+	version, err := s.storage.Permission().GetVersion()
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get permission version")
+		return nil, status.Errorf(codes.Internal, "failed to get permission version: %v", err)
+	}
+
+	permissions, err := effectivePermissions(s.storage, roles)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get effective permissions")
+		return nil, status.Errorf(codes.Internal, "failed to get effective permissions: %v", err)
+	}
+
+	response := pbu.GetEffectivePermissionsResponse{
+		Permissions: make([]*pbu.Permission, 0, len(permissions)),
+		Version:     version,
+	}
+
+	for _, permission := range permissions {
+		response.Permissions = append(response.Permissions, parsePermissionModel(permission))
+	}
+
+	return &response, nil
+}
+
+func parsePermissionModel(permission *repo.Permission) *pbu.Permission {
+	return &pbu.Permission{
+		Id:        permission.ID,
+		UserType:  permission.UserType,
+		Resource:  permission.Resource,
+		Action:    permission.Action,
+		Condition: permission.Condition,
+		Effect:    permission.Effect,
+	}
+}
+
+func parsePermissionsResult(result *repo.GetPermissionsResult) *pbu.GetAllPermissionsResponse {
+	response := pbu.GetAllPermissionsResponse{
+		Permissions: make([]*pbu.Permission, 0),
+		Count:       result.Count,
+	}
+
+	for _, permission := range result.Permissions {
+		response.Permissions = append(response.Permissions, parsePermissionModel(permission))
+	}
+
+	return &response
+}
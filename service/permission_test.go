@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePermissionRepo struct {
+	repo.PermissionStorageI
+	permissions []*repo.Permission
+}
+
+func (f *fakePermissionRepo) GetAll(params *repo.GetPermissionsParams) (*repo.GetPermissionsResult, error) {
+	result := repo.GetPermissionsResult{Permissions: make([]*repo.Permission, 0)}
+	for _, p := range f.permissions {
+		if p.UserType == params.UserType {
+			result.Permissions = append(result.Permissions, p)
+		}
+	}
+	return &result, nil
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	strg := &fakeStorage{permissions: &fakePermissionRepo{permissions: []*repo.Permission{
+		{UserType: "editor", Resource: "posts", Action: "*", Effect: repo.PermissionAllow},
+		{UserType: "editor", Resource: "comments", Action: "read", Effect: repo.PermissionAllow},
+		{UserType: "editor", Resource: "users", Action: "read", Effect: repo.PermissionAllow},
+		{UserType: "editor", Resource: "users", Action: "*", Effect: repo.PermissionDeny},
+		{UserType: "moderator", Resource: "posts", Action: "delete", Effect: repo.PermissionDeny},
+		{UserType: "moderator", Resource: "settings", Action: "*", Effect: repo.PermissionDeny},
+	}}}
+
+	permissions, err := effectivePermissions(strg, []string{"editor", "moderator"})
+	require.NoError(t, err)
+
+	listed := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		listed = append(listed, p.Effect+" "+p.Resource+":"+p.Action)
+	}
+
+	// the covered allow is dropped, the deny narrowing "posts:*" is listed
+	// next to it and the ones that touch no listed allow are left out
+	require.Equal(t, []string{
+		"allow posts:*",
+		"allow comments:read",
+		"deny posts:delete",
+	}, listed)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+)
+
+// SyncPolicy makes the permissions table match the policy file and returns
+// the changes. With dryRun the changes are only computed.
+func SyncPolicy(strg storage.StorageI, file *policy.File, dryRun bool) (*policy.Diff, error) {
+	result, err := strg.Permission().GetAll(&repo.GetPermissionsParams{})
+	if err != nil {
+		return nil, err
+	}
+
+	current := make([]policy.Rule, 0, len(result.Permissions))
+	for _, p := range result.Permissions {
+		current = append(current, policy.Rule{
+			Role:      p.UserType,
+			Resource:  p.Resource,
+			Action:    p.Action,
+			Condition: p.Condition,
+			Effect:    p.Effect,
+		})
+	}
+
+	diff := policy.Compare(current, file.Rules())
+	if dryRun {
+		return diff, nil
+	}
+
+	roles := make([]*repo.Role, 0, len(file.Roles))
+	for _, r := range file.Roles {
+		roles = append(roles, &repo.Role{
+			Name:        r.Name,
+			Description: r.Description,
+		})
+	}
+
+	err = strg.Permission().Sync(roles, permissionsFromRules(diff.Added), permissionsFromRules(diff.Removed))
+	if err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func permissionsFromRules(rules []policy.Rule) []*repo.Permission {
+	permissions := make([]*repo.Permission, 0, len(rules))
+
+	for _, r := range rules {
+		permissions = append(permissions, &repo.Permission{
+			UserType:  r.Role,
+			Resource:  r.Resource,
+			Action:    r.Action,
+			Condition: r.Condition,
+			Effect:    r.Effect,
+		})
+	}
+
+	return permissions
+}
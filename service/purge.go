@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Values of config.UserPurgeMode
+const (
+	PurgeModeDelete    = "delete"
+	PurgeModeAnonymize = "anonymize"
+)
+
+const purgeInterval = time.Hour
+
+// RunUserPurge purges the users deleted longer than the grace period ago
+// every hour until the context is canceled
+func RunUserPurge(ctx context.Context, strg storage.StorageI, cfg *config.Config, logger *logrus.Logger) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purgeUsers(strg, cfg, logger)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeUsers(strg storage.StorageI, cfg *config.Config, logger *logrus.Logger) {
+	before := time.Now().Add(-cfg.UserPurgeAfter)
+
+	var (
+		count int64
+		err   error
+	)
+
+	if cfg.UserPurgeMode == PurgeModeDelete {
+		count, err = strg.User().PurgeDeleted(before)
+	} else {
+		count, err = strg.User().AnonymizeDeleted(before)
+	}
+	if err != nil {
+		logger.WithError(err).Error("failed to purge deleted users")
+		return
+	}
+
+	if count > 0 {
+		logger.WithField("count", count).WithField("mode", cfg.UserPurgeMode).Info("purged deleted users")
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// builtInRoles are the roles the service itself relies on, they can't be
+// renamed or deleted.
+var builtInRoles = map[string]bool{
+	repo.UserTypeSuperAdmin: true,
+	repo.UserTypeUser:       true,
+	repo.OrganizationOwner:  true,
+}
+
+type RoleService struct {
+	pbu.UnimplementedRoleServiceServer
+	storage storage.StorageI
+	cfg     *config.Config
+	logger  *logrus.Logger
+}
+
+func NewRoleService(strg storage.StorageI, cfg *config.Config, logger *logrus.Logger) *RoleService {
+	return &RoleService{
+		storage: strg,
+		cfg:     cfg,
+		logger:  logger,
+	}
+}
+
+func (s *RoleService) Create(ctx context.Context, req *pbu.Role) (*pbu.Role, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	role, err := s.storage.Role().Create(&repo.Role{
+		Name:        req.Name,
+		Description: req.Description,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to create role")
+		return nil, status.Errorf(codes.Internal, "failed to create a role: %v", err)
+	}
+
+	return parseRoleModel(role), nil
+}
+
+func (s *RoleService) Get(ctx context.Context, req *pbu.GetRoleRequest) (*pbu.Role, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	role, err := s.storage.Role().Get(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get a role: %v", err)
+	}
+
+	return parseRoleModel(role), nil
+}
+
+func (s *RoleService) GetAll(ctx context.Context, req *pbu.GetAllRolesRequest) (*pbu.GetAllRolesResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	result, err := s.storage.Role().GetAll(&repo.GetRolesParams{
+		Limit:  usersPageSize(req.Limit),
+		Page:   req.Page,
+		Search: req.Search,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get all roles")
+		return nil, status.Errorf(codes.Internal, "failed to get all roles: %v", err)
+	}
+
+	response := pbu.GetAllRolesResponse{
+		Roles: make([]*pbu.Role, 0),
+		Count: result.Count,
+	}
+
+	for _, role := range result.Roles {
+		response.Roles = append(response.Roles, parseRoleModel(role))
+	}
+
+	return &response, nil
+}
+
+func (s *RoleService) Update(ctx context.Context, req *pbu.Role) (*pbu.Role, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	current, err := s.storage.Role().Get(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get a role: %v", err)
+	}
+
+	if builtInRoles[current.Name] && req.Name != current.Name {
+		return nil, status.Error(codes.FailedPrecondition, "built_in_role")
+	}
+
+	role, err := s.storage.Role().Update(&repo.Role{
+		ID:          req.Id,
+		Name:        req.Name,
+		Description: req.Description,
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to update role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update a role: %v", err)
+	}
+
+	return parseRoleModel(role), nil
+}
+
+func (s *RoleService) Delete(ctx context.Context, req *pbu.GetRoleRequest) (*emptypb.Empty, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	role, err := s.storage.Role().Get(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get a role: %v", err)
+	}
+
+	if builtInRoles[role.Name] {
+		return nil, status.Error(codes.FailedPrecondition, "built_in_role")
+	}
+
+	err = s.storage.Role().Delete(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to delete role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if isForeignKeyViolation(err) {
+			return nil, status.Error(codes.FailedPrecondition, "role_in_use")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to delete a role: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) AssignRole(ctx context.Context, req *pbu.UserRoleRequest) (*pbu.UserRolesResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.Role().AssignRole(req.UserId, req.Role)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to assign role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "role %q not found", req.Role)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to assign role: %v", err)
+	}
+
+	return s.getUserRoles(req.UserId)
+}
+
+func (s *RoleService) RevokeRole(ctx context.Context, req *pbu.UserRoleRequest) (*pbu.UserRolesResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	err := s.storage.Role().RevokeRole(req.UserId, req.Role)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to revoke role")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "user does not have role %q", req.Role)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to revoke role: %v", err)
+	}
+
+	return s.getUserRoles(req.UserId)
+}
+
+func (s *RoleService) GetUserRoles(ctx context.Context, req *pbu.GetUserRequest) (*pbu.UserRolesResponse, error) {
+	if _, err := requireSuperAdmin(ctx, s.cfg, s.storage); err != nil {
+		return nil, err
+	}
+
+	return s.getUserRoles(req.Id)
+}
+
+func (s *RoleService) getUserRoles(userID int64) (*pbu.UserRolesResponse, error) {
+	roles, err := s.storage.Role().GetUserRoles(userID)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get user roles")
+		return nil, status.Errorf(codes.Internal, "failed to get user roles: %v", err)
+	}
+
+	return &pbu.UserRolesResponse{
+		UserId: userID,
+		Roles:  roles,
+	}, nil
+}
+
+func parseRoleModel(role *repo.Role) *pbu.Role {
+	return &pbu.Role{
+		Id:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		CreatedAt:   role.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pbu "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type fakeStorage struct {
+	storage.StorageI
+	roles       *fakeRoleRepo
+	permissions *fakePermissionRepo
+}
+
+func (f *fakeStorage) Role() repo.RoleStorageI {
+	return f.roles
+}
+
+func (f *fakeStorage) Permission() repo.PermissionStorageI {
+	return f.permissions
+}
+
+type fakeRoleRepo struct {
+	repo.RoleStorageI
+	userRoles map[int64][]string
+	versions  map[int64]int64
+}
+
+func (f *fakeRoleRepo) RevokeRole(userID int64, role string) error {
+	roles := make([]string, 0, len(f.userRoles[userID]))
+	for _, r := range f.userRoles[userID] {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	f.userRoles[userID] = roles
+	f.versions[userID]++
+	return nil
+}
+
+func (f *fakeRoleRepo) GetRolesVersion(userID int64) (int64, error) {
+	return f.versions[userID], nil
+}
+
+func (f *fakeRoleRepo) GetAll(params *repo.GetRolesParams) (*repo.GetRolesResult, error) {
+	return &repo.GetRolesResult{Roles: []*repo.Role{}}, nil
+}
+
+func TestRoleServiceRevokedSuperAdmin(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{AuthSecretKey: "secret"}
+	roles := &fakeRoleRepo{
+		userRoles: map[int64][]string{1: {repo.UserTypeSuperAdmin}},
+		versions:  map[int64]int64{1: 1},
+	}
+	s := NewRoleService(&fakeStorage{roles: roles}, cfg, logger)
+
+	// the interceptor leaves no payload in the context of unprotected
+	// methods, so the service verifies the bearer token itself
+	login := func() context.Context {
+		token, _, err := utils.CreateToken(cfg, &utils.TokenParams{
+			UserID:       1,
+			Roles:        roles.userRoles[1],
+			RolesVersion: roles.versions[1],
+			Duration:     time.Minute,
+		})
+		require.NoError(t, err)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	ctx := login()
+	_, err := s.GetAll(ctx, &pbu.GetAllRolesRequest{})
+	require.NoError(t, err)
+
+	require.NoError(t, roles.RevokeRole(1, repo.UserTypeSuperAdmin))
+
+	// the token issued before the revocation is stale
+	_, err = s.GetAll(ctx, &pbu.GetAllRolesRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// and a new one no longer carries the role
+	_, err = s.GetAll(login(), &pbu.GetAllRolesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
+	"github.com/ibrat-muslim/blog_app_user_service/config"
 	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/authz"
+	grpcPkg "github.com/ibrat-muslim/blog_app_user_service/pkg/grpc_client"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/username"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
 	"github.com/ibrat-muslim/blog_app_user_service/storage"
 	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
 	"github.com/sirupsen/logrus"
@@ -15,22 +21,64 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+// Page sizes of GetAll, larger limits are lowered to the maximum
+const (
+	defaultUsersPageSize = 20
+	maxUsersPageSize     = 100
+
+	defaultSuggestionsLimit = 10
+	maxSuggestionsLimit     = 50
+
+	// maxBatchGetUsers limits the ids and usernames of a BatchGetUsers request together
+	maxBatchGetUsers = 100
+)
+
 type UserService struct {
 	pb.UnimplementedUserServiceServer
-	storage  storage.StorageI
-	inMemory storage.InMemoryStorageI
-	logger   *logrus.Logger
+	storage   storage.StorageI
+	inMemory  storage.InMemoryStorageI
+	index     *storage.UserIndex
+	usernames *username.Rules
+	cfg       *config.Config
+	logger    *logrus.Logger
+	notifier  *notifier
 }
 
-func NewUserService(strg storage.StorageI, inMemory storage.InMemoryStorageI, logger *logrus.Logger) *UserService {
+func NewUserService(strg storage.StorageI, inMemory storage.InMemoryStorageI, index *storage.UserIndex, usernames *username.Rules, grpcClient grpcPkg.GrpcClientI, cfg *config.Config, logger *logrus.Logger) *UserService {
 	return &UserService{
-		storage:  strg,
-		inMemory: inMemory,
-		logger:   logger,
+		storage:   strg,
+		inMemory:  inMemory,
+		index:     index,
+		usernames: usernames,
+		cfg:       cfg,
+		logger:    logger,
+		notifier: &notifier{
+			storage:    strg,
+			inMemory:   inMemory,
+			grpcClient: grpcClient,
+			logger:     logger,
+		},
 	}
 }
 
 func (s *UserService) Create(ctx context.Context, req *pb.User) (*pb.User, error) {
+	if req.Username != "" {
+		if err := s.usernames.Validate(req.Username); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		payload, ok := authz.PayloadFromContext(ctx)
+		admin := ok && payload.HasRole(repo.UserTypeSuperAdmin)
+
+		if !admin && s.usernames.IsReserved(req.Username) {
+			return nil, status.Error(codes.InvalidArgument, "username is reserved")
+		}
+
+		if err := s.checkUsernameTaken(req.Username); err != nil {
+			return nil, err
+		}
+	}
+
 	user, err := s.storage.User().Create(&repo.User{
 		FirstName:       req.FirstName,
 		LastName:        req.LastName,
@@ -44,6 +92,9 @@ func (s *UserService) Create(ctx context.Context, req *pb.User) (*pb.User, error
 	})
 	if err != nil {
 		s.logger.WithError(err).Error("failed to create user")
+		if isUniqueViolation(err, "users_username_lower_key") {
+			return nil, status.Error(codes.AlreadyExists, "username is taken")
+		}
 		return nil, status.Errorf(codes.Internal, "failed to create a user: %v", err)
 	}
 
@@ -76,12 +127,173 @@ func (s *UserService) GetByEmail(ctx context.Context, req *pb.EmailRequest) (*pb
 	return parseUserModel(user), nil
 }
 
-func (s *UserService) GetAll(ctx context.Context, req *pb.GetAllUsersRequest) (*pb.GetAllUsersResponse, error) {
-	result, err := s.storage.User().GetAll(&repo.GetUsersParams{
-		Limit:  req.Limit,
-		Page:   req.Page,
-		Search: req.Search,
+// BatchGetUsers resolves many ids and usernames at once, every one of them
+// gets an entry, marked not found when there is no such user
+func (s *UserService) BatchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchGetUsersResponse, error) {
+	if len(req.Ids)+len(req.Usernames) > maxBatchGetUsers {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids and usernames can be requested", maxBatchGetUsers)
+	}
+
+	response := pb.BatchGetUsersResponse{
+		Users:           make(map[int64]*pb.BatchGetUsersEntry, len(req.Ids)),
+		UsersByUsername: make(map[string]*pb.BatchGetUsersEntry, len(req.Usernames)),
+	}
+
+	for _, id := range req.Ids {
+		response.Users[id] = &pb.BatchGetUsersEntry{NotFound: true}
+	}
+
+	// usernames match in any case, the entries are keyed as requested
+	requested := make(map[string][]string, len(req.Usernames))
+	for _, username := range req.Usernames {
+		response.UsersByUsername[username] = &pb.BatchGetUsersEntry{NotFound: true}
+
+		lower := strings.ToLower(username)
+		requested[lower] = append(requested[lower], username)
+	}
+
+	if len(req.Ids)+len(req.Usernames) == 0 {
+		return &response, nil
+	}
+
+	users, err := s.storage.User().GetByIDs(req.Ids, req.Usernames)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to batch get users")
+		return nil, status.Errorf(codes.Internal, "failed to batch get users: %v", err)
+	}
+
+	for _, user := range users {
+		user.Password = ""
+		entry := &pb.BatchGetUsersEntry{User: parseUserModel(user)}
+
+		if _, ok := response.Users[user.ID]; ok {
+			response.Users[user.ID] = entry
+		}
+
+		if user.Username != "" {
+			for _, username := range requested[strings.ToLower(user.Username)] {
+				response.UsersByUsername[username] = entry
+			}
+		}
+	}
+
+	return &response, nil
+}
+
+func (s *UserService) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, status.Error(codes.InvalidArgument, "query is required")
+	}
+
+	result, err := s.storage.User().Search(&repo.SearchUsersParams{
+		Query: req.Query,
+		Limit: usersPageSize(req.Limit),
+		Page:  req.Page,
 	})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to search users")
+		return nil, status.Errorf(codes.Internal, "failed to search users: %v", err)
+	}
+
+	response := pb.SearchUsersResponse{
+		Results: make([]*pb.UserSearchResult, 0, len(result)),
+	}
+
+	for _, hit := range result {
+		response.Results = append(response.Results, &pb.UserSearchResult{
+			User:      parseUserModel(hit.User),
+			Rank:      hit.Rank,
+			Highlight: hit.Highlight,
+		})
+	}
+
+	return &response, nil
+}
+
+// AutocompleteUsers suggests the most popular users whose username starts
+// with the prefix, from the Redis index instead of Postgres
+func (s *UserService) AutocompleteUsers(ctx context.Context, req *pb.AutocompleteUsersRequest) (*pb.AutocompleteUsersResponse, error) {
+	prefix := strings.TrimPrefix(strings.TrimSpace(req.Prefix), "@")
+	if prefix == "" {
+		return nil, status.Error(codes.InvalidArgument, "prefix is required")
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSuggestionsLimit
+	} else if limit > maxSuggestionsLimit {
+		limit = maxSuggestionsLimit
+	}
+
+	suggestions, err := s.index.Suggest(prefix, int(limit))
+	if err != nil {
+		s.logger.WithError(err).Error("failed to autocomplete users")
+		return nil, status.Errorf(codes.Internal, "failed to autocomplete users: %v", err)
+	}
+
+	response := pb.AutocompleteUsersResponse{
+		Users: make([]*pb.UserSuggestion, 0, len(suggestions)),
+	}
+
+	for _, suggestion := range suggestions {
+		response.Users = append(response.Users, &pb.UserSuggestion{
+			Id:              suggestion.ID,
+			Username:        suggestion.Username,
+			FirstName:       suggestion.FirstName,
+			LastName:        suggestion.LastName,
+			ProfileImageUrl: suggestion.ProfileImageUrl,
+		})
+	}
+
+	return &response, nil
+}
+
+func (s *UserService) IncrementPopularity(ctx context.Context, req *pb.IncrementPopularityRequest) (*emptypb.Empty, error) {
+	err := s.storage.User().IncrementPopularity(req.UserId, req.Delta)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to increment popularity")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to increment popularity: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *UserService) GetAll(ctx context.Context, req *pb.GetAllUsersRequest) (*pb.GetAllUsersResponse, error) {
+	params, err := usersFilterParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Limit = usersPageSize(req.Limit)
+	params.Page = req.Page
+	params.SkipCount = req.SkipCount
+
+	params.Sort, err = parseSort(req.Sort, repo.UserSortableFields)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first page of the default order is the first keyset page, so
+	// clients paging by number get tokens to continue with
+	if params.Page <= 1 && len(params.Sort) == 0 {
+		params.Page = 0
+	}
+
+	if req.PageToken != "" {
+		if params.Page != 0 || len(params.Sort) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "page_token can not be combined with page or sort")
+		}
+
+		params.Cursor = &repo.UserCursor{}
+		if err := utils.DecodePageToken(req.PageToken, params.Cursor); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+	}
+
+	result, err := s.storage.User().GetAll(params)
 	if err != nil {
 		s.logger.WithError(err).Error("failed to get all users")
 		return nil, status.Errorf(codes.Internal, "failed to get all users: %v", err)
@@ -96,31 +308,132 @@ func (s *UserService) GetAll(ctx context.Context, req *pb.GetAllUsersRequest) (*
 		response.Users = append(response.Users, parseUserModel(user))
 	}
 
+	if result.Next != nil {
+		response.NextPageToken, err = utils.EncodePageToken(result.Next)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create page token: %v", err)
+		}
+	}
+
+	if result.Prev != nil {
+		response.PrevPageToken, err = utils.EncodePageToken(result.Prev)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create page token: %v", err)
+		}
+	}
+
 	return &response, nil
 }
 
-func (s *UserService) Update(ctx context.Context, req *pb.User) (*pb.User, error) {
+// Update writes the fields listed in the update mask, or the non-empty
+// fields without a mask. Listed fields left empty are cleared.
+func (s *UserService) Update(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	if req.User == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	fields, err := updateFields(req.User, req.UpdateMask.GetPaths())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkUsernameChange(ctx, req.User, fields); err != nil {
+		return nil, err
+	}
+
 	user, err := s.storage.User().Update(&repo.User{
-		ID:              req.Id,
-		FirstName:       req.FirstName,
-		LastName:        req.LastName,
-		PhoneNumber:     req.PhoneNumber,
-		Gender:          req.Gender,
-		Username:        req.Username,
-		ProfileImageUrl: req.ProfileImageUrl,
-	})
+		ID:              req.User.Id,
+		FirstName:       req.User.FirstName,
+		LastName:        req.User.LastName,
+		PhoneNumber:     req.User.PhoneNumber,
+		Gender:          req.User.Gender,
+		Username:        req.User.Username,
+		ProfileImageUrl: req.User.ProfileImageUrl,
+	}, fields)
 	if err != nil {
 		s.logger.WithError(err).Error("failed to update user")
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
+		if isUniqueViolation(err, "users_username_lower_key") {
+			return nil, status.Error(codes.AlreadyExists, "username is taken")
+		}
 		return nil, status.Errorf(codes.Internal, "failed to update a user: %v", err)
 	}
 
 	return parseUserModel(user), nil
 }
 
+// updateFields returns the fields Update writes: the paths of the mask
+// without duplicates, or the non-empty fields of user without a mask
+func updateFields(user *pb.User, paths []string) ([]string, error) {
+	fields := make([]string, 0, len(repo.UserUpdatableFields))
+
+	if len(paths) == 0 {
+		for _, field := range repo.UserUpdatableFields {
+			if userField(user, field) != "" {
+				fields = append(fields, field)
+			}
+		}
+
+		if len(fields) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "nothing to update")
+		}
+
+		return fields, nil
+	}
+
+	for _, path := range paths {
+		if !containsString(repo.UserUpdatableFields, path) {
+			return nil, status.Errorf(codes.InvalidArgument, "field %q can not be updated", path)
+		}
+
+		if containsString(fields, path) {
+			continue
+		}
+
+		if (path == "first_name" || path == "last_name") && userField(user, path) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s can not be cleared", path)
+		}
+
+		fields = append(fields, path)
+	}
+
+	return fields, nil
+}
+
+// userField returns the value of an updatable field of user
+func userField(user *pb.User, field string) string {
+	switch field {
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "phone_number":
+		return user.PhoneNumber
+	case "gender":
+		return user.Gender
+	case "username":
+		return user.Username
+	case "profile_image_url":
+		return user.ProfileImageUrl
+	}
+	return ""
+}
+
+// Delete marks the user deleted. A user deleted by someone else is told
+// their account was deleted, it can be restored until it is purged.
 func (s *UserService) Delete(ctx context.Context, req *pb.GetUserRequest) (*emptypb.Empty, error) {
+	var deleted *repo.User
+	if payload, ok := authz.PayloadFromContext(ctx); ok && payload.UserID != req.Id {
+		user, err := s.storage.User().Get(req.Id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithError(err).Error("failed to get user")
+			return nil, status.Errorf(codes.Internal, "failed to get a user: %v", err)
+		}
+		deleted = user
+	}
+
 	err := s.storage.User().Delete(req.Id)
 	if err != nil {
 		s.logger.WithError(err).Error("failed to delete user")
@@ -130,11 +443,205 @@ func (s *UserService) Delete(ctx context.Context, req *pb.GetUserRequest) (*empt
 		return nil, status.Errorf(codes.Internal, "failed to delete a user: %v", err)
 	}
 
+	if deleted != nil {
+		go s.notifier.notify(deleted, EmailTypeAccountDeleted, map[string]string{
+			"time": time.Now().Format(time.RFC1123),
+		})
+	}
+
 	return &emptypb.Empty{}, nil
 }
 
+// Restore undoes the deletion of a user that was not purged yet
+func (s *UserService) Restore(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	err := s.storage.User().Restore(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to restore user")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "no deleted user to restore")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to restore a user: %v", err)
+	}
+
+	user, err := s.storage.User().Get(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get user")
+		return nil, status.Errorf(codes.Internal, "failed to get a user: %v", err)
+	}
+
+	return parseUserModel(user), nil
+}
+
+// Purge removes a deleted user permanently without waiting for the grace period
+func (s *UserService) Purge(ctx context.Context, req *pb.GetUserRequest) (*emptypb.Empty, error) {
+	err := s.storage.User().Purge(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to purge user")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "no deleted user to purge")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to purge a user: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *UserService) GetNotificationSettings(ctx context.Context, req *pb.GetUserRequest) (*pb.NotificationSettings, error) {
+	disabledTypes, err := s.storage.Notification().GetDisabledTypes(req.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get notification settings")
+		return nil, status.Errorf(codes.Internal, "failed to get notification settings: %v", err)
+	}
+
+	return &pb.NotificationSettings{
+		UserId:        req.Id,
+		DisabledTypes: disabledTypes,
+	}, nil
+}
+
+func (s *UserService) UpdateNotificationSettings(ctx context.Context, req *pb.NotificationSettings) (*pb.NotificationSettings, error) {
+	for _, t := range req.DisabledTypes {
+		if !optionalEmailTypes[t] {
+			return nil, status.Errorf(codes.InvalidArgument, "notification %q cannot be disabled", t)
+		}
+	}
+
+	err := s.storage.Notification().SetDisabledTypes(req.UserId, req.DisabledTypes)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to update notification settings")
+		return nil, status.Errorf(codes.Internal, "failed to update notification settings: %v", err)
+	}
+
+	return s.GetNotificationSettings(ctx, &pb.GetUserRequest{Id: req.UserId})
+}
+
+func (s *UserService) GetLoginHistory(ctx context.Context, req *pb.GetLoginHistoryRequest) (*pb.GetLoginHistoryResponse, error) {
+	params := repo.GetLoginEventsParams{
+		UserID: req.UserId,
+		Limit:  usersPageSize(req.Limit),
+		Page:   req.Page,
+	}
+
+	var err error
+	if req.From != "" {
+		params.From, err = time.Parse(time.RFC3339, req.From)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid from date: %v", err)
+		}
+	}
+
+	if req.To != "" {
+		params.To, err = time.Parse(time.RFC3339, req.To)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid to date: %v", err)
+		}
+	}
+
+	result, err := s.storage.LoginEvent().GetAll(&params)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get login history")
+		return nil, status.Errorf(codes.Internal, "failed to get login history: %v", err)
+	}
+
+	response := pb.GetLoginHistoryResponse{
+		Events: make([]*pb.LoginEvent, 0),
+		Count:  result.Count,
+	}
+
+	for _, event := range result.Events {
+		response.Events = append(response.Events, &pb.LoginEvent{
+			Id:        event.ID,
+			UserId:    event.UserID,
+			Email:     event.Email,
+			Type:      event.Type,
+			Success:   event.Success,
+			IpAddress: event.IPAddress,
+			UserAgent: event.UserAgent,
+			Reason:    event.Reason,
+			Country:   event.Country,
+			RiskScore: event.RiskScore,
+			CreatedAt: event.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return &response, nil
+}
+
+// usersFilterParams returns the params of the filters of the request
+func usersFilterParams(req *pb.GetAllUsersRequest) (*repo.GetUsersParams, error) {
+	params := repo.GetUsersParams{
+		Search:         req.Search,
+		OrganizationID: req.OrganizationId,
+		IDs:            req.Ids,
+		Types:          req.Types,
+		Roles:          req.Roles,
+		Gender:         req.Gender,
+	}
+
+	var err error
+
+	if req.CreatedFrom != "" {
+		params.CreatedFrom, err = time.Parse(time.RFC3339, req.CreatedFrom)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid created_from: %v", err)
+		}
+	}
+
+	if req.CreatedTo != "" {
+		params.CreatedTo, err = time.Parse(time.RFC3339, req.CreatedTo)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid created_to: %v", err)
+		}
+	}
+
+	if req.HasUsername != nil {
+		params.HasUsername = &req.HasUsername.Value
+	}
+
+	if req.Verified != nil {
+		params.Verified = &req.Verified.Value
+	}
+
+	return &params, nil
+}
+
+// usersPageSize applies the default page size to a missing limit and
+// lowers a limit above the maximum
+func usersPageSize(limit int32) int32 {
+	if limit <= 0 {
+		return defaultUsersPageSize
+	}
+
+	if limit > maxUsersPageSize {
+		return maxUsersPageSize
+	}
+
+	return limit
+}
+
+// parseSort parses fields like "last_name" or "-created_at", a leading
+// minus sorts in descending order
+func parseSort(sort []string, sortable map[string]bool) ([]repo.SortField, error) {
+	fields := make([]repo.SortField, 0, len(sort))
+
+	for _, s := range sort {
+		field := repo.SortField{
+			Field: strings.TrimPrefix(s, "-"),
+			Desc:  strings.HasPrefix(s, "-"),
+		}
+
+		if !sortable[field.Field] {
+			return nil, status.Errorf(codes.InvalidArgument, "can not sort by %q", field.Field)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
 func parseUserModel(user *repo.User) *pb.User {
-	return &pb.User{
+	result := &pb.User{
 		Id:              user.ID,
 		FirstName:       user.FirstName,
 		LastName:        user.LastName,
@@ -146,5 +653,12 @@ func parseUserModel(user *repo.User) *pb.User {
 		ProfileImageUrl: user.ProfileImageUrl,
 		Type:            user.Type,
 		CreatedAt:       user.CreatedAt.Format(time.RFC3339),
+		Verified:        user.Verified,
 	}
+
+	if user.UsernameChangedAt != nil {
+		result.UsernameChangedAt = user.UsernameChangedAt.Format(time.RFC3339)
+	}
+
+	return result
 }
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/config"
+	pb "github.com/ibrat-muslim/blog_app_user_service/genproto/user_service"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/authz"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/username"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxUsernameSuggestions is how many free usernames CheckUsernameAvailability
+// suggests, twice as many candidates are checked against the taken ones
+const maxUsernameSuggestions = 5
+
+// Reasons of CheckUsernameAvailability for unavailable usernames
+const (
+	usernameInvalid  = "invalid"
+	usernameReserved = "reserved"
+	usernameTaken    = "taken"
+)
+
+// maxUsernameLength is the length of the username column
+const maxUsernameLength = 30
+
+func NewUsernameRules(cfg *config.Config) (*username.Rules, error) {
+	if cfg.UsernameMaxLength > maxUsernameLength {
+		return nil, fmt.Errorf("username max length can not be above %d", maxUsernameLength)
+	}
+
+	reserved := append([]string{}, username.DefaultReserved...)
+	for _, name := range strings.Split(cfg.UsernameReserved, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			reserved = append(reserved, name)
+		}
+	}
+
+	return username.NewRules(cfg.UsernameChars, cfg.UsernameMinLength, cfg.UsernameMaxLength, reserved)
+}
+
+func (s *UserService) CheckUsernameAvailability(ctx context.Context, req *pb.CheckUsernameAvailabilityRequest) (*pb.CheckUsernameAvailabilityResponse, error) {
+	name := strings.TrimPrefix(strings.TrimSpace(req.Username), "@")
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+
+	response := pb.CheckUsernameAvailabilityResponse{
+		Suggestions: make([]string, 0),
+	}
+
+	if err := s.usernames.Validate(name); err != nil {
+		response.Reason = usernameInvalid
+		response.Message = err.Error()
+	} else if s.usernames.IsReserved(name) {
+		response.Reason = usernameReserved
+		response.Message = "username is reserved"
+	} else {
+		taken, err := s.storage.User().TakenUsernames([]string{name})
+		if err != nil {
+			s.logger.WithError(err).Error("failed to check username")
+			return nil, status.Errorf(codes.Internal, "failed to check username: %v", err)
+		}
+
+		if len(taken) == 0 {
+			response.Available = true
+			return &response, nil
+		}
+
+		response.Reason = usernameTaken
+		response.Message = "username is taken"
+	}
+
+	suggestions, err := s.suggestUsernames(name)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to suggest usernames")
+		return nil, status.Errorf(codes.Internal, "failed to suggest usernames: %v", err)
+	}
+	response.Suggestions = suggestions
+
+	return &response, nil
+}
+
+// suggestUsernames returns free usernames made from the name
+func (s *UserService) suggestUsernames(name string) ([]string, error) {
+	candidates := s.usernames.Candidates(name, 2*maxUsernameSuggestions)
+	if len(candidates) == 0 {
+		return []string{}, nil
+	}
+
+	taken, err := s.storage.User().TakenUsernames(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	isTaken := make(map[string]bool, len(taken))
+	for _, t := range taken {
+		isTaken[t] = true
+	}
+
+	suggestions := make([]string, 0, maxUsernameSuggestions)
+	for _, candidate := range candidates {
+		if len(suggestions) == maxUsernameSuggestions {
+			break
+		}
+		if !isTaken[strings.ToLower(candidate)] {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+
+	return suggestions, nil
+}
+
+// checkUsernameChange checks the username Update is going to set. Admins
+// may take reserved names and are not held by the cool-down.
+func (s *UserService) checkUsernameChange(ctx context.Context, user *pb.User, fields []string) error {
+	if len(fields) > 0 && !containsString(fields, "username") {
+		return nil
+	}
+
+	// an empty username clears it
+	if user.Username == "" {
+		return nil
+	}
+
+	if err := s.usernames.Validate(user.Username); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	current, err := s.storage.User().Get(user.Id)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to get user")
+		if errors.Is(err, sql.ErrNoRows) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		return status.Errorf(codes.Internal, "failed to get user: %v", err)
+	}
+
+	if current.Username == user.Username {
+		return nil
+	}
+
+	payload, ok := authz.PayloadFromContext(ctx)
+	admin := ok && payload.HasRole(repo.UserTypeSuperAdmin)
+
+	if !admin {
+		if s.usernames.IsReserved(user.Username) {
+			return status.Error(codes.InvalidArgument, "username is reserved")
+		}
+
+		if current.UsernameChangedAt != nil {
+			next := current.UsernameChangedAt.Add(s.cfg.UsernameChangeCooldown)
+			if time.Now().Before(next) {
+				return status.Errorf(codes.FailedPrecondition, "username can be changed again after %s", next.Format(time.RFC3339))
+			}
+		}
+	}
+
+	// changing only the case keeps the user's own name
+	if strings.EqualFold(current.Username, user.Username) {
+		return nil
+	}
+
+	return s.checkUsernameTaken(user.Username)
+}
+
+func (s *UserService) checkUsernameTaken(name string) error {
+	taken, err := s.storage.User().TakenUsernames([]string{name})
+	if err != nil {
+		s.logger.WithError(err).Error("failed to check username")
+		return status.Errorf(codes.Internal, "failed to check username: %v", err)
+	}
+
+	if len(taken) > 0 {
+		return status.Error(codes.AlreadyExists, "username is taken")
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err violates the unique constraint or index
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type fakeSuggestion struct {
+	prefixes []string
+	score    float64
+	document string
+}
+
+// fakeInMemory is the InMemoryStorageI the tests of the package share
+type fakeInMemory struct {
+	published   []string
+	suggestions map[string]fakeSuggestion
+	values      map[string]string
+}
+
+func (f *fakeInMemory) Set(key, value string, exp time.Duration) error {
+	return f.SetMany(map[string]string{key: value}, exp)
+}
+
+func (f *fakeInMemory) Get(key string) (string, error) { return f.values[key], nil }
+
+func (f *fakeInMemory) Delete(key string) error {
+	delete(f.values, key)
+	return nil
+}
+
+func (f *fakeInMemory) Take(key string) (string, error) {
+	value := f.values[key]
+	delete(f.values, key)
+	return value, nil
+}
+
+func (f *fakeInMemory) Incr(key string, exp time.Duration) (int64, error) {
+	n, _ := strconv.ParseInt(f.values[key], 10, 64)
+	n++
+	return n, f.Set(key, strconv.FormatInt(n, 10), exp)
+}
+
+func (f *fakeInMemory) GetMany(keys []string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = f.values[key]
+	}
+	return values, nil
+}
+
+func (f *fakeInMemory) SetMany(values map[string]string, exp time.Duration) error {
+	if f.values == nil {
+		f.values = make(map[string]string)
+	}
+	for key, value := range values {
+		f.values[key] = value
+	}
+	return nil
+}
+
+func (f *fakeInMemory) TakeToken(key string, rate float64, burst int) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func (f *fakeInMemory) Publish(channel, message string) error {
+	f.published = append(f.published, channel)
+	return nil
+}
+
+func (f *fakeInMemory) Subscribe(ctx context.Context, channel string) <-chan string {
+	return make(chan string)
+}
+
+func (f *fakeInMemory) SetSuggestion(index, id string, prefixes []string, score float64, document string) error {
+	if f.suggestions == nil {
+		f.suggestions = make(map[string]fakeSuggestion)
+	}
+	f.suggestions[id] = fakeSuggestion{prefixes: prefixes, score: score, document: document}
+	return nil
+}
+
+func (f *fakeInMemory) DeleteSuggestion(index, id string) error {
+	delete(f.suggestions, id)
+	return nil
+}
+
+func (f *fakeInMemory) GetSuggestions(index, prefix string, limit int) ([]string, error) {
+	matches := make([]fakeSuggestion, 0)
+	for _, suggestion := range f.suggestions {
+		for _, p := range suggestion.prefixes {
+			if p == prefix {
+				matches = append(matches, suggestion)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+
+	documents := make([]string, 0)
+	for i := 0; i < len(matches) && i < limit; i++ {
+		documents = append(documents, matches[i].document)
+	}
+	return documents, nil
+}
+
+func (f *fakeInMemory) ClearSuggestions(index string) error {
+	f.suggestions = nil
+	return nil
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -10,8 +12,77 @@ import (
 type InMemoryStorageI interface {
 	Set(key, value string, exp time.Duration) error
 	Get(key string) (string, error)
+	Delete(key string) error
+	// Take returns the value of the key and deletes it atomically
+	Take(key string) (string, error)
+	// Incr increments the counter at key, a new counter expires after exp
+	Incr(key string, exp time.Duration) (int64, error)
+	// GetMany returns the values of the keys in order, "" for missing keys
+	GetMany(keys []string) ([]string, error)
+	SetMany(values map[string]string, exp time.Duration) error
+	TakeToken(key string, rate float64, burst int) (bool, time.Duration, error)
+	Publish(channel, message string) error
+	Subscribe(ctx context.Context, channel string) <-chan string
+	// SetSuggestion stores the document of the id and ranks the id by score
+	// under every prefix, the prefixes it had before are dropped
+	SetSuggestion(index, id string, prefixes []string, score float64, document string) error
+	DeleteSuggestion(index, id string) error
+	// GetSuggestions returns the documents of the lowest scored ids under the prefix
+	GetSuggestions(index, prefix string, limit int) ([]string, error)
+	ClearSuggestions(index string) error
 }
 
+// tokenBucketScript takes a token from the bucket stored at KEYS[1], refilling
+// it at ARGV[1] tokens per second up to ARGV[2] tokens. It returns whether a
+// token was taken and, if not, the milliseconds until the next one is available.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+
+return {allowed, retry}
+`)
+
+// incrScript increments the counter at KEYS[1] and sets a new counter to
+// expire after ARGV[1] milliseconds in the same step, so no counter is left
+// without an expiry
+var incrScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// getSuggestionsScript reads the documents of the first ARGV[1] ids of the
+// sorted set KEYS[1] from KEYS[2] in a single round trip
+var getSuggestionsScript = redis.NewScript(`
+local ids = redis.call('ZRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+if #ids == 0 then
+	return {}
+end
+return redis.call('HMGET', KEYS[2], unpack(ids))
+`)
+
 type storageRedis struct {
 	client *redis.Client
 }
@@ -36,4 +107,221 @@ func (r *storageRedis) Get(key string) (string, error) {
 		return "", err
 	}
 	return val, nil
-}
\ No newline at end of file
+}
+
+func (r *storageRedis) Delete(key string) error {
+	err := r.client.Del(context.Background(), key).Err()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *storageRedis) Take(key string) (string, error) {
+	val, err := r.client.GetDel(context.Background(), key).Result()
+	if err != nil {
+		return "", err
+	}
+	return val, nil
+}
+
+func (r *storageRedis) Incr(key string, exp time.Duration) (int64, error) {
+	return incrScript.Run(context.Background(), r.client, []string{key}, exp.Milliseconds()).Int64()
+}
+
+func (r *storageRedis) GetMany(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return []string{}, nil
+	}
+
+	result, err := r.client.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(result))
+	for i, value := range result {
+		if value, ok := value.(string); ok {
+			values[i] = value
+		}
+	}
+
+	return values, nil
+}
+
+func (r *storageRedis) SetMany(values map[string]string, exp time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	_, err := r.client.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(context.Background(), key, value, exp)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *storageRedis) TakeToken(key string, rate float64, burst int) (bool, time.Duration, error) {
+	result, err := tokenBucketScript.Run(context.Background(), r.client, []string{key}, rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (r *storageRedis) Publish(channel, message string) error {
+	err := r.client.Publish(context.Background(), channel, message).Err()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Subscribe returns the messages published to the channel
+// until the context is canceled
+func (r *storageRedis) Subscribe(ctx context.Context, channel string) <-chan string {
+	messages := make(chan string)
+	pubsub := r.client.Subscribe(ctx, channel)
+
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return messages
+}
+
+// maxSuggestionAttempts bounds the retries of a suggestion update that
+// raced with another update of the same id
+const maxSuggestionAttempts = 5
+
+// suggestionKeys returns the hash of the documents, the hash of the prefixes
+// each id is indexed under and the start of the prefix sorted sets. They
+// share the index as hash tag, so they live in one Redis Cluster slot.
+func suggestionKeys(index string) (documents, prefixes, prefix string) {
+	tag := "{" + index + "}"
+	return tag + ":documents", tag + ":prefixes", tag + ":prefix:"
+}
+
+func (r *storageRedis) SetSuggestion(index, id string, prefixes []string, score float64, document string) error {
+	ctx := context.Background()
+	documentsKey, prefixesKey, prefixKey := suggestionKeys(index)
+
+	return r.updateSuggestion(index, id, func(pipe redis.Pipeliner) {
+		for _, prefix := range prefixes {
+			pipe.ZAdd(ctx, prefixKey+prefix, &redis.Z{Score: score, Member: id})
+		}
+		pipe.HSet(ctx, documentsKey, id, document)
+		pipe.HSet(ctx, prefixesKey, id, strings.Join(prefixes, " "))
+	})
+}
+
+func (r *storageRedis) DeleteSuggestion(index, id string) error {
+	ctx := context.Background()
+	documentsKey, prefixesKey, _ := suggestionKeys(index)
+
+	return r.updateSuggestion(index, id, func(pipe redis.Pipeliner) {
+		pipe.HDel(ctx, documentsKey, id)
+		pipe.HDel(ctx, prefixesKey, id)
+	})
+}
+
+// updateSuggestion drops the id from the prefixes it is indexed under and
+// applies update in the same transaction. The transaction is retried when
+// the prefixes of the id change before it runs.
+func (r *storageRedis) updateSuggestion(index, id string, update func(pipe redis.Pipeliner)) error {
+	ctx := context.Background()
+	_, prefixesKey, prefixKey := suggestionKeys(index)
+
+	var err error
+	for attempt := 0; attempt < maxSuggestionAttempts; attempt++ {
+		err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+			old, err := tx.HGet(ctx, prefixesKey, id).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, prefix := range strings.Fields(old) {
+					pipe.ZRem(ctx, prefixKey+prefix, id)
+				}
+				update(pipe)
+				return nil
+			})
+			return err
+		}, prefixesKey)
+
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (r *storageRedis) GetSuggestions(index, prefix string, limit int) ([]string, error) {
+	documentsKey, _, prefixKey := suggestionKeys(index)
+
+	result, err := getSuggestionsScript.Run(context.Background(), r.client, []string{prefixKey + prefix, documentsKey}, limit).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]string, 0, len(result))
+	for _, document := range result {
+		// skip ids left without a document
+		if document, ok := document.(string); ok {
+			documents = append(documents, document)
+		}
+	}
+
+	return documents, nil
+}
+
+// ClearSuggestions deletes every key of the index
+func (r *storageRedis) ClearSuggestions(index string) error {
+	ctx := context.Background()
+	iter := r.client.Scan(ctx, 0, "{"+index+"}:*", 1000).Iterator()
+
+	keys := make([]string, 0)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+
+		if len(keys) == 1000 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		return r.client.Del(ctx, keys...).Err()
+	}
+
+	return nil
+}
@@ -0,0 +1,538 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+)
+
+const permissionsChannel = "permissions_changed"
+
+// userChangedPrefix starts the message of permissionsChannel that drops
+// only the roles of the user with the id that follows
+const userChangedPrefix = "user:"
+
+// maxCachedUsers bounds the users whose roles are kept, the cached roles
+// are dropped all at once when it is reached
+const maxCachedUsers = 100000
+
+type memberKey struct {
+	organizationID int64
+	userID         int64
+}
+
+type PermissionCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Loaded bool
+	Size   int
+}
+
+// PermissionCache keeps the whole permission matrix in memory grouped by role.
+// Every change made through it is published to Redis so all instances drop
+// their copy and reload it, checks made before the reload finishes go to Postgres.
+// The organization roles and the roles versions of users are kept as they are
+// read, a change of the roles of a user drops only theirs.
+type PermissionCache struct {
+	repo.PermissionStorageI
+	inMemory InMemoryStorageI
+	logger   *logrus.Logger
+
+	mu         sync.RWMutex
+	matrix     map[string][]*repo.Permission
+	size       int
+	version    int64
+	loaded     bool
+	generation uint64
+	loading    int32
+
+	hits   uint64
+	misses uint64
+
+	usersMu         sync.Mutex
+	members         map[memberKey][]string
+	rolesVersions   map[int64]int64
+	usersGeneration uint64
+}
+
+func NewPermissionCache(permissionRepo repo.PermissionStorageI, inMemory InMemoryStorageI, logger *logrus.Logger) *PermissionCache {
+	return &PermissionCache{
+		PermissionStorageI: permissionRepo,
+		inMemory:           inMemory,
+		logger:             logger,
+	}
+}
+
+// Run loads the matrix and reloads it on every invalidation signal
+// until the context is canceled
+func (c *PermissionCache) Run(ctx context.Context) {
+	messages := c.inMemory.Subscribe(ctx, permissionsChannel)
+
+	c.reload()
+
+	for message := range messages {
+		if strings.HasPrefix(message, userChangedPrefix) {
+			userID, err := strconv.ParseInt(strings.TrimPrefix(message, userChangedPrefix), 10, 64)
+			if err == nil {
+				c.dropUser(userID)
+				continue
+			}
+		}
+
+		c.reset()
+		c.reload()
+	}
+}
+
+func (c *PermissionCache) GetMatching(roles []string, resource, action string) ([]*repo.Permission, error) {
+	c.mu.RLock()
+	if c.loaded {
+		defer c.mu.RUnlock()
+		atomic.AddUint64(&c.hits, 1)
+
+		permissions := make([]*repo.Permission, 0)
+		for _, role := range roles {
+			for _, p := range c.matrix[role] {
+				if policy.MatchAction(p.Action, action) && policy.MatchResource(p.Resource, resource) {
+					permissions = append(permissions, p)
+				}
+			}
+		}
+		return permissions, nil
+	}
+	c.mu.RUnlock()
+
+	atomic.AddUint64(&c.misses, 1)
+	go c.reload()
+
+	return c.PermissionStorageI.GetMatching(roles, resource, action)
+}
+
+func (c *PermissionCache) GetVersion() (int64, error) {
+	c.mu.RLock()
+	if c.loaded {
+		defer c.mu.RUnlock()
+		atomic.AddUint64(&c.hits, 1)
+		return c.version, nil
+	}
+	c.mu.RUnlock()
+
+	atomic.AddUint64(&c.misses, 1)
+	go c.reload()
+
+	return c.PermissionStorageI.GetVersion()
+}
+
+func (c *PermissionCache) Create(permission *repo.Permission) (*repo.Permission, error) {
+	result, err := c.PermissionStorageI.Create(permission)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Invalidate()
+	return result, nil
+}
+
+func (c *PermissionCache) Delete(id int64) error {
+	err := c.PermissionStorageI.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	c.Invalidate()
+	return nil
+}
+
+func (c *PermissionCache) Replace(userType string, permissions []*repo.Permission) error {
+	err := c.PermissionStorageI.Replace(userType, permissions)
+	if err != nil {
+		return err
+	}
+
+	c.Invalidate()
+	return nil
+}
+
+func (c *PermissionCache) Sync(roles []*repo.Role, added, removed []*repo.Permission) error {
+	err := c.PermissionStorageI.Sync(roles, added, removed)
+	if err != nil {
+		return err
+	}
+
+	c.Invalidate()
+	return nil
+}
+
+// Invalidate drops the matrix of this instance and signals the other instances
+func (c *PermissionCache) Invalidate() {
+	c.reset()
+
+	err := c.inMemory.Publish(permissionsChannel, "")
+	if err != nil {
+		c.logger.WithError(err).Error("failed to publish permissions change")
+	}
+
+	go c.reload()
+}
+
+// InvalidateUser drops the cached roles of the user on every instance
+func (c *PermissionCache) InvalidateUser(userID int64) {
+	c.dropUser(userID)
+
+	err := c.inMemory.Publish(permissionsChannel, userChangedPrefix+strconv.FormatInt(userID, 10))
+	if err != nil {
+		c.logger.WithError(err).Error("failed to publish roles change")
+	}
+}
+
+func (c *PermissionCache) dropUser(userID int64) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	c.usersGeneration++
+	delete(c.rolesVersions, userID)
+	for key := range c.members {
+		if key.userID == userID {
+			delete(c.members, key)
+		}
+	}
+}
+
+func (c *PermissionCache) dropUsers() {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	c.usersGeneration++
+	c.members = nil
+	c.rolesVersions = nil
+}
+
+// memberRoles returns the cached roles of the member and the generation
+// to store the roles read from the database with
+func (c *PermissionCache) memberRoles(key memberKey) ([]string, bool, uint64) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	roles, ok := c.members[key]
+	return roles, ok, c.usersGeneration
+}
+
+// setMemberRoles keeps the roles unless a user was dropped since they were read
+func (c *PermissionCache) setMemberRoles(key memberKey, roles []string, generation uint64) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	if generation != c.usersGeneration {
+		return
+	}
+
+	if c.members == nil || len(c.members) >= maxCachedUsers {
+		c.members = make(map[memberKey][]string)
+	}
+	c.members[key] = roles
+}
+
+func (c *PermissionCache) rolesVersion(userID int64) (int64, bool, uint64) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	version, ok := c.rolesVersions[userID]
+	return version, ok, c.usersGeneration
+}
+
+func (c *PermissionCache) setRolesVersion(userID, version int64, generation uint64) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	if generation != c.usersGeneration {
+		return
+	}
+
+	if c.rolesVersions == nil || len(c.rolesVersions) >= maxCachedUsers {
+		c.rolesVersions = make(map[int64]int64)
+	}
+	c.rolesVersions[userID] = version
+}
+
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return PermissionCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Loaded: c.loaded,
+		Size:   c.size,
+	}
+}
+
+func (c *PermissionCache) reset() {
+	c.mu.Lock()
+	c.loaded = false
+	c.matrix = nil
+	c.size = 0
+	c.generation++
+	c.mu.Unlock()
+
+	c.dropUsers()
+}
+
+// reload loads the matrix unless a load is already running. A matrix read
+// before the latest reset is thrown away and loaded again.
+func (c *PermissionCache) reload() {
+	if !atomic.CompareAndSwapInt32(&c.loading, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.loading, 0)
+
+	for {
+		c.mu.RLock()
+		generation := c.generation
+		c.mu.RUnlock()
+
+		version, err := c.PermissionStorageI.GetVersion()
+		if err != nil {
+			c.logger.WithError(err).Error("failed to load permission version")
+			return
+		}
+
+		result, err := c.PermissionStorageI.GetAll(&repo.GetPermissionsParams{})
+		if err != nil {
+			c.logger.WithError(err).Error("failed to load permissions")
+			return
+		}
+
+		matrix := make(map[string][]*repo.Permission)
+		for _, p := range result.Permissions {
+			matrix[p.UserType] = append(matrix[p.UserType], p)
+		}
+
+		c.mu.Lock()
+		current := c.generation == generation
+		if current {
+			c.matrix = matrix
+			c.size = len(result.Permissions)
+			c.version = version
+			c.loaded = true
+		}
+		c.mu.Unlock()
+
+		if current {
+			return
+		}
+	}
+}
+
+// cachedRoleRepo invalidates the permission cache when roles are
+// renamed or deleted, the permissions table follows them by cascade.
+// The roles versions of users are served by the cache.
+type cachedRoleRepo struct {
+	repo.RoleStorageI
+	cache *PermissionCache
+}
+
+func (r *cachedRoleRepo) Update(role *repo.Role) (*repo.Role, error) {
+	result, err := r.RoleStorageI.Update(role)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.Invalidate()
+	return result, nil
+}
+
+func (r *cachedRoleRepo) Delete(id int64) error {
+	err := r.RoleStorageI.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	r.cache.Invalidate()
+	return nil
+}
+
+func (r *cachedRoleRepo) AssignRole(userID int64, role string) error {
+	err := r.RoleStorageI.AssignRole(userID, role)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(userID)
+	return nil
+}
+
+func (r *cachedRoleRepo) RevokeRole(userID int64, role string) error {
+	err := r.RoleStorageI.RevokeRole(userID, role)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(userID)
+	return nil
+}
+
+func (r *cachedRoleRepo) GetRolesVersion(userID int64) (int64, error) {
+	version, ok, generation := r.cache.rolesVersion(userID)
+	if ok {
+		return version, nil
+	}
+
+	version, err := r.RoleStorageI.GetRolesVersion(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	r.cache.setRolesVersion(userID, version, generation)
+	return version, nil
+}
+
+// cachedOrganizationRepo serves the roles of members from the permission
+// cache and drops them when memberships change
+type cachedOrganizationRepo struct {
+	repo.OrganizationStorageI
+	cache *PermissionCache
+}
+
+func (r *cachedOrganizationRepo) Create(organization *repo.Organization, owner int64) (*repo.Organization, error) {
+	result, err := r.OrganizationStorageI.Create(organization, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.InvalidateUser(owner)
+	return result, nil
+}
+
+func (r *cachedOrganizationRepo) Delete(id int64) error {
+	err := r.OrganizationStorageI.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	r.cache.Invalidate()
+	return nil
+}
+
+func (r *cachedOrganizationRepo) AddMember(organizationID, userID int64, role string) error {
+	err := r.OrganizationStorageI.AddMember(organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(userID)
+	return nil
+}
+
+func (r *cachedOrganizationRepo) RemoveMember(organizationID, userID int64, role string) error {
+	err := r.OrganizationStorageI.RemoveMember(organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(userID)
+	return nil
+}
+
+func (r *cachedOrganizationRepo) AcceptInvitation(id, userID int64) error {
+	err := r.OrganizationStorageI.AcceptInvitation(id, userID)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(userID)
+	return nil
+}
+
+func (r *cachedOrganizationRepo) GetMemberRoles(organizationID, userID int64) ([]string, error) {
+	key := memberKey{organizationID: organizationID, userID: userID}
+
+	roles, ok, generation := r.cache.memberRoles(key)
+	if ok {
+		return roles, nil
+	}
+
+	roles, err := r.OrganizationStorageI.GetMemberRoles(organizationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.setMemberRoles(key, roles, generation)
+	return roles, nil
+}
+
+// cachedUserRepo drops the roles version of users that are deleted or
+// restored, which moves it
+type cachedUserRepo struct {
+	repo.UserStorageI
+	cache *PermissionCache
+}
+
+func (r *cachedUserRepo) Delete(id int64) error {
+	err := r.UserStorageI.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(id)
+	return nil
+}
+
+func (r *cachedUserRepo) Restore(id int64) error {
+	err := r.UserStorageI.Restore(id)
+	if err != nil {
+		return err
+	}
+
+	r.cache.InvalidateUser(id)
+	return nil
+}
+
+type cachedStorage struct {
+	StorageI
+	cache        *PermissionCache
+	user         repo.UserStorageI
+	role         repo.RoleStorageI
+	organization repo.OrganizationStorageI
+}
+
+// WithPermissionCache returns strg with its permission checks served by the cache
+func WithPermissionCache(strg StorageI, cache *PermissionCache) StorageI {
+	return &cachedStorage{
+		StorageI: strg,
+		cache:    cache,
+		user: &cachedUserRepo{
+			UserStorageI: strg.User(),
+			cache:        cache,
+		},
+		role: &cachedRoleRepo{
+			RoleStorageI: strg.Role(),
+			cache:        cache,
+		},
+		organization: &cachedOrganizationRepo{
+			OrganizationStorageI: strg.Organization(),
+			cache:                cache,
+		},
+	}
+}
+
+func (s *cachedStorage) User() repo.UserStorageI {
+	return s.user
+}
+
+func (s *cachedStorage) Permission() repo.PermissionStorageI {
+	return s.cache
+}
+
+func (s *cachedStorage) Role() repo.RoleStorageI {
+	return s.role
+}
+
+func (s *cachedStorage) Organization() repo.OrganizationStorageI {
+	return s.organization
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePermissionRepo struct {
+	permissions []*repo.Permission
+	checks      int
+}
+
+func (f *fakePermissionRepo) GetMatching(roles []string, resource, action string) ([]*repo.Permission, error) {
+	f.checks++
+	permissions := make([]*repo.Permission, 0)
+	for _, p := range f.permissions {
+		for _, role := range roles {
+			if p.UserType == role && p.Resource == resource && p.Action == action {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func (f *fakePermissionRepo) Create(permission *repo.Permission) (*repo.Permission, error) {
+	f.permissions = append(f.permissions, permission)
+	return permission, nil
+}
+
+func (f *fakePermissionRepo) Delete(id int64) error {
+	return nil
+}
+
+func (f *fakePermissionRepo) GetAll(params *repo.GetPermissionsParams) (*repo.GetPermissionsResult, error) {
+	return &repo.GetPermissionsResult{
+		Permissions: f.permissions,
+		Count:       int32(len(f.permissions)),
+	}, nil
+}
+
+func (f *fakePermissionRepo) Replace(userType string, permissions []*repo.Permission) error {
+	return nil
+}
+
+func (f *fakePermissionRepo) Sync(roles []*repo.Role, added, removed []*repo.Permission) error {
+	return nil
+}
+
+func (f *fakePermissionRepo) GetVersion() (int64, error) {
+	return int64(len(f.permissions)), nil
+}
+
+func TestPermissionCache(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	permissionRepo := &fakePermissionRepo{
+		permissions: []*repo.Permission{
+			{UserType: repo.UserTypeUser, Resource: "posts", Action: "create"},
+		},
+	}
+	inMemory := &fakeInMemory{}
+
+	cache := NewPermissionCache(permissionRepo, inMemory, logger)
+
+	// the first check goes to the database and loads the matrix
+	permissions, err := cache.GetMatching([]string{repo.UserTypeUser}, "posts", "create")
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	require.Equal(t, 1, permissionRepo.checks)
+
+	require.Eventually(t, func() bool { return cache.Stats().Loaded }, time.Second, time.Millisecond)
+
+	permissions, err = cache.GetMatching([]string{"editor", repo.UserTypeUser}, "posts", "create")
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+
+	permissions, err = cache.GetMatching([]string{repo.UserTypeUser}, "posts", "delete")
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+
+	require.Equal(t, 1, permissionRepo.checks)
+	version, err := cache.GetVersion()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+
+	require.Equal(t, PermissionCacheStats{Hits: 3, Misses: 1, Loaded: true, Size: 1}, cache.Stats())
+
+	_, err = cache.Create(&repo.Permission{UserType: repo.UserTypeSuperAdmin, Resource: "*", Action: "*"})
+	require.NoError(t, err)
+	require.Equal(t, []string{permissionsChannel}, inMemory.published)
+
+	require.Eventually(t, func() bool { return cache.Stats().Size == 2 }, time.Second, time.Millisecond)
+
+	permissions, err = cache.GetMatching([]string{repo.UserTypeSuperAdmin}, "categories/123/posts", "delete")
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	require.Equal(t, "*", permissions[0].Resource)
+
+	version, err = cache.GetVersion()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+}
+
+type fakeOrganizationRepo struct {
+	repo.OrganizationStorageI
+	members map[int64][]string
+	lookups int
+}
+
+func (f *fakeOrganizationRepo) AddMember(organizationID, userID int64, role string) error {
+	f.members[userID] = append(f.members[userID], role)
+	return nil
+}
+
+func (f *fakeOrganizationRepo) GetMemberRoles(organizationID, userID int64) ([]string, error) {
+	f.lookups++
+	return f.members[userID], nil
+}
+
+func TestMemberRolesCache(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	inMemory := &fakeInMemory{}
+	cache := NewPermissionCache(&fakePermissionRepo{}, inMemory, logger)
+	organizations := &cachedOrganizationRepo{
+		OrganizationStorageI: &fakeOrganizationRepo{members: map[int64][]string{1: {"editor"}}},
+		cache:                cache,
+	}
+	fake := organizations.OrganizationStorageI.(*fakeOrganizationRepo)
+
+	for i := 0; i < 2; i++ {
+		roles, err := organizations.GetMemberRoles(10, 1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"editor"}, roles)
+	}
+	require.Equal(t, 1, fake.lookups)
+
+	// a membership change drops the roles of the member on every instance
+	require.NoError(t, organizations.AddMember(10, 1, "owner"))
+	require.Equal(t, []string{permissionsChannel}, inMemory.published)
+
+	roles, err := organizations.GetMemberRoles(10, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"editor", "owner"}, roles)
+	require.Equal(t, 2, fake.lookups)
+}
+
+type fakeRoleRepo struct {
+	repo.RoleStorageI
+	versions map[int64]int64
+}
+
+func (f *fakeRoleRepo) GetRolesVersion(userID int64) (int64, error) {
+	return f.versions[userID], nil
+}
+
+func TestDeletedUserRolesVersion(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cache := NewPermissionCache(&fakePermissionRepo{}, &fakeInMemory{}, logger)
+	fake := &fakeRoleRepo{versions: map[int64]int64{}}
+	roles := &cachedRoleRepo{RoleStorageI: fake, cache: cache}
+	users := &cachedUserRepo{
+		UserStorageI: &fakeUserRepo{users: map[int64]*repo.User{1: {ID: 1}}},
+		cache:        cache,
+	}
+
+	version, err := roles.GetRolesVersion(1)
+	require.NoError(t, err)
+	require.Zero(t, version)
+
+	// the users trigger moves the version, the cached one is dropped
+	fake.versions[1]++
+	require.NoError(t, users.Delete(1))
+
+	version, err = roles.GetRolesVersion(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+}
@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/jmoiron/sqlx"
+)
+
+type loginEventRepo struct {
+	db *sqlx.DB
+}
+
+func NewLoginEvent(db *sqlx.DB) repo.LoginEventStorageI {
+	return &loginEventRepo{
+		db: db,
+	}
+}
+
+func (lr *loginEventRepo) Create(event *repo.LoginEvent) (*repo.LoginEvent, error) {
+	query := `
+		INSERT INTO login_events (
+			user_id,
+			email,
+			type,
+			success,
+			ip_address,
+			user_agent,
+			reason,
+			country,
+			asn,
+			latitude,
+			longitude,
+			risk_score
+		) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at
+	`
+
+	row := lr.db.QueryRow(
+		query,
+		utils.NullInt64(event.UserID),
+		event.Email,
+		event.Type,
+		event.Success,
+		utils.NullString(event.IPAddress),
+		utils.NullString(event.UserAgent),
+		utils.NullString(event.Reason),
+		utils.NullString(event.Country),
+		utils.NullInt64(event.ASN),
+		utils.NullFloat64(event.Latitude),
+		utils.NullFloat64(event.Longitude),
+		event.RiskScore,
+	)
+
+	err := row.Scan(
+		&event.ID,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func (lr *loginEventRepo) GetAll(params *repo.GetLoginEventsParams) (*repo.GetLoginEventsResult, error) {
+	result := repo.GetLoginEventsResult{
+		Events: make([]*repo.LoginEvent, 0),
+		Count:  0,
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if params.UserID != 0 {
+		args = append(args, params.UserID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+
+	if !params.From.IsZero() {
+		args = append(args, params.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if !params.To.IsZero() {
+		args = append(args, params.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	offset := (params.Page - 1) * params.Limit
+	if offset < 0 {
+		offset = 0
+	}
+	limit := fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+
+	query := `
+		SELECT
+			id,
+			user_id,
+			email,
+			type,
+			success,
+			ip_address,
+			user_agent,
+			reason,
+			country,
+			asn,
+			latitude,
+			longitude,
+			risk_score,
+			created_at
+		FROM login_events
+		` + filter + `
+		ORDER BY created_at DESC
+		` + limit
+
+	rows, err := lr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanLoginEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Events = append(result.Events, event)
+	}
+
+	queryCount := `SELECT count(1) FROM login_events ` + filter
+
+	err = lr.db.QueryRow(queryCount, args...).Scan(&result.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (lr *loginEventRepo) GetRecentSuccessful(userID int64, limit int) ([]*repo.LoginEvent, error) {
+	query := `
+		SELECT
+			id,
+			user_id,
+			email,
+			type,
+			success,
+			ip_address,
+			user_agent,
+			reason,
+			country,
+			asn,
+			latitude,
+			longitude,
+			risk_score,
+			created_at
+		FROM login_events
+		WHERE user_id = $1 AND type = $2 AND success
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+
+	rows, err := lr.db.Query(query, userID, repo.LoginEventTypeLogin, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := make([]*repo.LoginEvent, 0)
+	for rows.Next() {
+		event, err := scanLoginEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func scanLoginEvent(rows *sql.Rows) (*repo.LoginEvent, error) {
+	var (
+		event                                 repo.LoginEvent
+		userID, asn                           sql.NullInt64
+		ipAddress, userAgent, reason, country sql.NullString
+		latitude, longitude                   sql.NullFloat64
+	)
+
+	err := rows.Scan(
+		&event.ID,
+		&userID,
+		&event.Email,
+		&event.Type,
+		&event.Success,
+		&ipAddress,
+		&userAgent,
+		&reason,
+		&country,
+		&asn,
+		&latitude,
+		&longitude,
+		&event.RiskScore,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.UserID = userID.Int64
+	event.IPAddress = ipAddress.String
+	event.UserAgent = userAgent.String
+	event.Reason = reason.String
+	event.Country = country.String
+	event.ASN = asn.Int64
+	event.Latitude = latitude.Float64
+	event.Longitude = longitude.Float64
+
+	return &event, nil
+}
@@ -0,0 +1,48 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateLoginEvent(t *testing.T) {
+	u := createUser(t)
+
+	event, err := strg.LoginEvent().Create(&repo.LoginEvent{
+		UserID:    u.ID,
+		Email:     u.Email,
+		Type:      repo.LoginEventTypeLogin,
+		Success:   true,
+		IPAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	require.NotZero(t, event.ID)
+
+	events, err := strg.LoginEvent().GetAll(&repo.GetLoginEventsParams{
+		UserID: u.ID,
+		Limit:  10,
+		Page:   1,
+	})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 1)
+	require.Equal(t, int32(1), events.Count)
+
+	_, err = strg.LoginEvent().Create(&repo.LoginEvent{
+		UserID: u.ID,
+		Email:  u.Email,
+		Type:   repo.LoginEventTypeTokenRefresh,
+	})
+	require.NoError(t, err)
+
+	// page 0 is the first page
+	events, err = strg.LoginEvent().GetAll(&repo.GetLoginEventsParams{
+		UserID: u.ID,
+		Limit:  10,
+	})
+	require.NoError(t, err)
+	require.Len(t, events.Events, 2)
+
+	deleteUser(u.ID, t)
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/jmoiron/sqlx"
+)
+
+type notificationRepo struct {
+	db *sqlx.DB
+}
+
+func NewNotification(db *sqlx.DB) repo.NotificationStorageI {
+	return &notificationRepo{
+		db: db,
+	}
+}
+
+func (nr *notificationRepo) GetDisabledTypes(userID int64) ([]string, error) {
+	query := `SELECT type FROM notification_opt_outs WHERE user_id = $1 ORDER BY type`
+
+	rows, err := nr.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	types := make([]string, 0)
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	return types, rows.Err()
+}
+
+func (nr *notificationRepo) SetDisabledTypes(userID int64, types []string) error {
+	tx, err := nr.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM notification_opt_outs WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO notification_opt_outs (user_id, type) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	for _, t := range types {
+		if _, err := tx.Exec(query, userID, t); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
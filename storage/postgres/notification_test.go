@@ -0,0 +1,20 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDisabledNotificationTypes(t *testing.T) {
+	u := createUser(t)
+
+	err := strg.Notification().SetDisabledTypes(u.ID, []string{"new_login_email"})
+	require.NoError(t, err)
+
+	types, err := strg.Notification().GetDisabledTypes(u.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"new_login_email"}, types)
+
+	deleteUser(u.ID, t)
+}
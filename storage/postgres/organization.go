@@ -0,0 +1,461 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type organizationRepo struct {
+	db *sqlx.DB
+}
+
+func NewOrganization(db *sqlx.DB) repo.OrganizationStorageI {
+	return &organizationRepo{
+		db: db,
+	}
+}
+
+func (or *organizationRepo) Create(organization *repo.Organization, owner int64) (*repo.Organization, error) {
+	tx, err := or.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (
+			name,
+			slug
+		) VALUES($1, $2)
+		RETURNING id, created_at
+	`
+
+	err = tx.QueryRow(
+		query,
+		organization.Name,
+		organization.Slug,
+	).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	queryOwner := `
+		INSERT INTO organization_members (organization_id, user_id, role_id)
+		SELECT $1, $2, id FROM roles WHERE name = $3
+	`
+
+	_, err = tx.Exec(queryOwner, organization.ID, owner, repo.OrganizationOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+func (or *organizationRepo) Get(id int64) (*repo.Organization, error) {
+	var result repo.Organization
+
+	query := `
+		SELECT
+			id,
+			name,
+			slug,
+			created_at
+		FROM organizations
+		WHERE id = $1
+	`
+
+	err := or.db.QueryRow(query, id).Scan(
+		&result.ID,
+		&result.Name,
+		&result.Slug,
+		&result.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (or *organizationRepo) GetAll(params *repo.GetOrganizationsParams) (*repo.GetOrganizationsResult, error) {
+	result := repo.GetOrganizationsResult{
+		Organizations: make([]*repo.Organization, 0),
+		Count:         0,
+	}
+
+	var (
+		args   []interface{}
+		filter = " WHERE true "
+	)
+
+	if params.Search != "" {
+		args = append(args, "%"+params.Search+"%")
+		filter += fmt.Sprintf(" AND (name ILIKE $%d OR slug ILIKE $%d) ", len(args), len(args))
+	}
+
+	if params.UserID != 0 {
+		args = append(args, params.UserID)
+		filter += fmt.Sprintf(" AND id IN (SELECT organization_id FROM organization_members WHERE user_id = $%d) ", len(args))
+	}
+
+	offset := (params.Page - 1) * params.Limit
+	limit := fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+
+	query := `
+		SELECT
+			id,
+			name,
+			slug,
+			created_at
+		FROM organizations
+		` + filter + `
+		ORDER BY name
+		` + limit
+
+	rows, err := or.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var organization repo.Organization
+
+		err := rows.Scan(
+			&organization.ID,
+			&organization.Name,
+			&organization.Slug,
+			&organization.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Organizations = append(result.Organizations, &organization)
+	}
+
+	queryCount := `SELECT count(1) FROM organizations ` + filter
+
+	err = or.db.QueryRow(queryCount, args...).Scan(&result.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (or *organizationRepo) Update(organization *repo.Organization) (*repo.Organization, error) {
+	query := `
+		UPDATE organizations SET
+			name = $1,
+			slug = $2
+		WHERE id = $3
+		RETURNING created_at
+	`
+
+	err := or.db.QueryRow(
+		query,
+		organization.Name,
+		organization.Slug,
+		organization.ID,
+	).Scan(&organization.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+func (or *organizationRepo) Delete(id int64) error {
+	query := `DELETE FROM organizations WHERE id = $1`
+
+	result, err := or.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (or *organizationRepo) AddMember(organizationID, userID int64, role string) error {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role_id)
+		SELECT $1, $2, id FROM roles WHERE name = $3
+		ON CONFLICT DO NOTHING
+	`
+
+	result, err := or.db.Exec(query, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		// either the role does not exist or the member already has it
+		var exists bool
+		err := or.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
+func (or *organizationRepo) RemoveMember(organizationID, userID int64, role string) error {
+	tx, err := or.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// locking the organization serializes removals, so two of them can not
+	// each leave the other owner as the last one
+	_, err = tx.Exec(`SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+			AND ($3 = '' OR role_id = (SELECT id FROM roles WHERE name = $3))
+	`
+
+	result, err := tx.Exec(query, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	queryOwners := `
+		SELECT EXISTS (
+			SELECT 1 FROM organization_members
+			WHERE organization_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+		)
+	`
+
+	var hasOwner bool
+	err = tx.QueryRow(queryOwners, organizationID, repo.OrganizationOwner).Scan(&hasOwner)
+	if err != nil {
+		return err
+	}
+
+	if !hasOwner {
+		return repo.ErrLastOwner
+	}
+
+	return tx.Commit()
+}
+
+func (or *organizationRepo) GetMembers(organizationID int64) ([]*repo.OrganizationMember, error) {
+	query := `
+		SELECT om.user_id, array_agg(r.name ORDER BY r.name) FROM organization_members om
+		INNER JOIN roles r ON r.id = om.role_id
+		WHERE om.organization_id = $1
+		GROUP BY om.user_id
+		ORDER BY om.user_id
+	`
+
+	rows, err := or.db.Query(query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := make([]*repo.OrganizationMember, 0)
+	for rows.Next() {
+		var member repo.OrganizationMember
+
+		if err := rows.Scan(&member.UserID, pq.Array(&member.Roles)); err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+
+	return members, rows.Err()
+}
+
+func (or *organizationRepo) GetMemberRoles(organizationID, userID int64) ([]string, error) {
+	query := `
+		SELECT r.name FROM organization_members om
+		INNER JOIN roles r ON r.id = om.role_id
+		WHERE om.organization_id = $1 AND om.user_id = $2
+		ORDER BY r.name
+	`
+
+	rows, err := or.db.Query(query, organizationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := make([]string, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (or *organizationRepo) CreateInvitation(invitation *repo.OrganizationInvitation) (*repo.OrganizationInvitation, error) {
+	query := `
+		INSERT INTO organization_invitations (
+			organization_id,
+			email,
+			role,
+			token,
+			invited_by,
+			expires_at
+		) VALUES($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := or.db.QueryRow(
+		query,
+		invitation.OrganizationID,
+		invitation.Email,
+		invitation.Role,
+		invitation.Token,
+		invitation.InvitedBy,
+		invitation.ExpiresAt,
+	).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+func (or *organizationRepo) GetInvitation(token string) (*repo.OrganizationInvitation, error) {
+	var (
+		result     repo.OrganizationInvitation
+		invitedBy  sql.NullInt64
+		acceptedAt sql.NullTime
+	)
+
+	query := `
+		SELECT
+			id,
+			organization_id,
+			email,
+			role,
+			token,
+			invited_by,
+			expires_at,
+			accepted_at,
+			created_at
+		FROM organization_invitations
+		WHERE token = $1
+	`
+
+	err := or.db.QueryRow(query, token).Scan(
+		&result.ID,
+		&result.OrganizationID,
+		&result.Email,
+		&result.Role,
+		&result.Token,
+		&invitedBy,
+		&result.ExpiresAt,
+		&acceptedAt,
+		&result.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result.InvitedBy = invitedBy.Int64
+	if acceptedAt.Valid {
+		result.AcceptedAt = &acceptedAt.Time
+	}
+
+	return &result, nil
+}
+
+func (or *organizationRepo) AcceptInvitation(id, userID int64) error {
+	tx, err := or.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var (
+		organizationID int64
+		role           string
+	)
+
+	query := `
+		UPDATE organization_invitations SET accepted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND accepted_at IS NULL
+		RETURNING organization_id, role
+	`
+
+	err = tx.QueryRow(query, id).Scan(&organizationID, &role)
+	if err != nil {
+		return err
+	}
+
+	queryMember := `
+		INSERT INTO organization_members (organization_id, user_id, role_id)
+		SELECT $1, $2, id FROM roles WHERE name = $3
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = tx.Exec(queryMember, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bxcodec/faker/v4"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrganizationMembers(t *testing.T) {
+	owner := createUser(t)
+	member := createUser(t)
+
+	organization, err := strg.Organization().Create(&repo.Organization{
+		Name: faker.Name(),
+		Slug: faker.Word() + faker.UUIDDigit()[:8],
+	}, owner.ID)
+	require.NoError(t, err)
+	require.NotZero(t, organization.ID)
+
+	roles, err := strg.Organization().GetMemberRoles(organization.ID, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{repo.OrganizationOwner}, roles)
+
+	invitation, err := strg.Organization().CreateInvitation(&repo.OrganizationInvitation{
+		OrganizationID: organization.ID,
+		Email:          member.Email,
+		Role:           repo.UserTypeUser,
+		Token:          faker.UUIDDigit(),
+		InvitedBy:      owner.ID,
+		ExpiresAt:      time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	err = strg.Organization().AcceptInvitation(invitation.ID, member.ID)
+	require.NoError(t, err)
+
+	invitation, err = strg.Organization().GetInvitation(invitation.Token)
+	require.NoError(t, err)
+	require.NotNil(t, invitation.AcceptedAt)
+
+	members, err := strg.Organization().GetMembers(organization.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	organizations, err := strg.Organization().GetAll(&repo.GetOrganizationsParams{
+		Limit:  10,
+		Page:   1,
+		UserID: member.ID,
+	})
+	require.NoError(t, err)
+	require.Len(t, organizations.Organizations, 1)
+
+	err = strg.Organization().RemoveMember(organization.ID, member.ID, "")
+	require.NoError(t, err)
+
+	err = strg.Organization().RemoveMember(organization.ID, owner.ID, repo.OrganizationOwner)
+	require.ErrorIs(t, err, repo.ErrLastOwner)
+
+	err = strg.Organization().Delete(organization.ID)
+	require.NoError(t, err)
+
+	deleteUser(member.ID, t)
+	deleteUser(owner.ID, t)
+}
@@ -2,10 +2,14 @@ package postgres
 
 import (
 	"database/sql"
-	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/policy"
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
 	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type permissionRepo struct {
@@ -18,21 +22,249 @@ func NewPermission(db *sqlx.DB) repo.PermissionStorageI {
 	}
 }
 
-func (pr *permissionRepo) CheckPermission(userType, resource, action string) (bool, error) {
+func (pr *permissionRepo) GetMatching(roles []string, resource, action string) ([]*repo.Permission, error) {
 	query := `
-		SELECT id FROM permissions 
-		WHERE user_type = $1 AND resource = $2 AND action = $3
+		SELECT
+			id,
+			user_type,
+			resource,
+			action,
+			condition,
+			effect
+		FROM permissions
+		WHERE user_type = ANY($1) AND (action = $2 OR action = '*')
+		ORDER BY user_type, resource, action, condition, effect
 	`
 
-	var id int64
-	err := pr.db.QueryRow(query, userType, resource, action).Scan(&id)
+	rows, err := pr.db.Query(query, pq.Array(roles), action)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := make([]*repo.Permission, 0)
+	for rows.Next() {
+		permission, err := scanPermission(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if policy.MatchResource(permission.Resource, resource) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return permissions, rows.Err()
+}
+
+func (pr *permissionRepo) Create(permission *repo.Permission) (*repo.Permission, error) {
+	query := `
+		INSERT INTO permissions (
+			user_type,
+			resource,
+			action,
+			condition,
+			effect
+		) VALUES($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	err := pr.db.QueryRow(
+		query,
+		permission.UserType,
+		permission.Resource,
+		permission.Action,
+		permission.Condition,
+		permission.Effect,
+	).Scan(&permission.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return permission, nil
+}
+
+func (pr *permissionRepo) Delete(id int64) error {
+	query := `DELETE FROM permissions WHERE id = $1`
+
+	result, err := pr.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (pr *permissionRepo) GetAll(params *repo.GetPermissionsParams) (*repo.GetPermissionsResult, error) {
+	result := repo.GetPermissionsResult{
+		Permissions: make([]*repo.Permission, 0),
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if params.UserType != "" {
+		args = append(args, params.UserType)
+		conditions = append(conditions, fmt.Sprintf("user_type = $%d", len(args)))
+	}
+
+	if params.Resource != "" {
+		args = append(args, params.Resource)
+		conditions = append(conditions, fmt.Sprintf("resource = $%d", len(args)))
+	}
+
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT
+			id,
+			user_type,
+			resource,
+			action,
+			condition,
+			effect
+		FROM permissions
+		` + filter + `
+		ORDER BY user_type, resource, action, condition, effect
+	`
+
+	rows, err := pr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		permission, err := scanPermission(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Permissions = append(result.Permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Count = int32(len(result.Permissions))
+
+	return &result, nil
+}
+
+// Replace makes permissions the only permissions of the user type
+func (pr *permissionRepo) Replace(userType string, permissions []*repo.Permission) error {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM permissions WHERE user_type = $1`, userType)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO permissions (user_type, resource, action, condition, effect) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+	`
+
+	for _, p := range permissions {
+		if _, err := tx.Exec(query, userType, p.Resource, p.Action, p.Condition, p.Effect); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
 
+func (pr *permissionRepo) Sync(roles []*repo.Role, added, removed []*repo.Permission) error {
+	tx, err := pr.db.Begin()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return false, nil
+		return err
+	}
+
+	defer tx.Rollback()
+
+	queryRole := `
+		INSERT INTO roles (name, description) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+	`
+
+	for _, r := range roles {
+		if _, err := tx.Exec(queryRole, r.Name, utils.NullString(r.Description)); err != nil {
+			return err
+		}
+	}
+
+	queryDelete := `
+		DELETE FROM permissions
+		WHERE user_type = $1 AND resource = $2 AND action = $3 AND condition = $4 AND effect = $5
+	`
+
+	for _, p := range removed {
+		if _, err := tx.Exec(queryDelete, p.UserType, p.Resource, p.Action, p.Condition, p.Effect); err != nil {
+			return err
+		}
+	}
+
+	queryInsert := `
+		INSERT INTO permissions (user_type, resource, action, condition, effect) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+	`
+
+	for _, p := range added {
+		if _, err := tx.Exec(queryInsert, p.UserType, p.Resource, p.Action, p.Condition, p.Effect); err != nil {
+			return err
 		}
-		return false, err
 	}
 
-	return true, nil
+	return tx.Commit()
+}
+
+func (pr *permissionRepo) GetVersion() (int64, error) {
+	var version int64
+
+	err := pr.db.QueryRow(`SELECT version FROM permission_version`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func scanPermission(rows *sql.Rows) (*repo.Permission, error) {
+	var permission repo.Permission
+
+	err := rows.Scan(
+		&permission.ID,
+		&permission.UserType,
+		&permission.Resource,
+		&permission.Action,
+		&permission.Condition,
+		&permission.Effect,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &permission, nil
 }
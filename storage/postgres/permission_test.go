@@ -0,0 +1,39 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePermission(t *testing.T) {
+	version, err := strg.Permission().GetVersion()
+	require.NoError(t, err)
+
+	p, err := strg.Permission().Create(&repo.Permission{
+		UserType: repo.UserTypeUser,
+		Resource: "test-resource",
+		Action:   "create",
+		Effect:   repo.PermissionAllow,
+	})
+	require.NoError(t, err)
+	require.NotZero(t, p.ID)
+
+	newVersion, err := strg.Permission().GetVersion()
+	require.NoError(t, err)
+	require.Greater(t, newVersion, version)
+
+	matching, err := strg.Permission().GetMatching([]string{repo.UserTypeUser}, "test-resource/1", "create")
+	require.NoError(t, err)
+	require.Len(t, matching, 1)
+
+	permissions, err := strg.Permission().GetAll(&repo.GetPermissionsParams{
+		Resource: "test-resource",
+	})
+	require.NoError(t, err)
+	require.Len(t, permissions.Permissions, 1)
+
+	err = strg.Permission().Delete(p.ID)
+	require.NoError(t, err)
+}
@@ -0,0 +1,283 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/jmoiron/sqlx"
+)
+
+type roleRepo struct {
+	db *sqlx.DB
+}
+
+func NewRole(db *sqlx.DB) repo.RoleStorageI {
+	return &roleRepo{
+		db: db,
+	}
+}
+
+func (rr *roleRepo) Create(role *repo.Role) (*repo.Role, error) {
+	query := `
+		INSERT INTO roles (
+			name,
+			description
+		) VALUES($1, $2)
+		RETURNING id, created_at
+	`
+
+	err := rr.db.QueryRow(
+		query,
+		role.Name,
+		utils.NullString(role.Description),
+	).Scan(
+		&role.ID,
+		&role.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (rr *roleRepo) Get(id int64) (*repo.Role, error) {
+	var (
+		result      repo.Role
+		description sql.NullString
+	)
+
+	query := `
+		SELECT
+			id,
+			name,
+			description,
+			created_at
+		FROM roles
+		WHERE id = $1
+	`
+
+	err := rr.db.QueryRow(query, id).Scan(
+		&result.ID,
+		&result.Name,
+		&description,
+		&result.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Description = description.String
+
+	return &result, nil
+}
+
+func (rr *roleRepo) GetAll(params *repo.GetRolesParams) (*repo.GetRolesResult, error) {
+	result := repo.GetRolesResult{
+		Roles: make([]*repo.Role, 0),
+		Count: 0,
+	}
+
+	var args []interface{}
+
+	filter := ""
+	if params.Search != "" {
+		args = append(args, "%"+params.Search+"%")
+		filter = " WHERE name ILIKE $1 OR description ILIKE $1 "
+	}
+
+	page := params.Page
+	if page < 1 {
+		page = 1
+	}
+
+	offset := (page - 1) * params.Limit
+	limit := fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+
+	query := `
+		SELECT
+			id,
+			name,
+			description,
+			created_at
+		FROM roles
+		` + filter + `
+		ORDER BY name
+		` + limit
+
+	rows, err := rr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			role        repo.Role
+			description sql.NullString
+		)
+
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&description,
+			&role.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		role.Description = description.String
+
+		result.Roles = append(result.Roles, &role)
+	}
+
+	queryCount := `SELECT count(1) FROM roles ` + filter
+
+	err = rr.db.QueryRow(queryCount, args...).Scan(&result.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (rr *roleRepo) Update(role *repo.Role) (*repo.Role, error) {
+	query := `
+		UPDATE roles SET
+			name = $1,
+			description = $2
+		WHERE id = $3
+		RETURNING created_at
+	`
+
+	err := rr.db.QueryRow(
+		query,
+		role.Name,
+		utils.NullString(role.Description),
+		role.ID,
+	).Scan(&role.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (rr *roleRepo) Delete(id int64) error {
+	query := `DELETE FROM roles WHERE id = $1`
+
+	result, err := rr.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (rr *roleRepo) AssignRole(userID int64, role string) error {
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING
+	`
+
+	result, err := rr.db.Exec(query, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		// either the role does not exist or the user already has it
+		var exists bool
+		err := rr.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
+func (rr *roleRepo) RevokeRole(userID int64, role string) error {
+	query := `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+	`
+
+	result, err := rr.db.Exec(query, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (rr *roleRepo) GetUserRoles(userID int64) ([]string, error) {
+	query := `
+		SELECT r.name FROM user_roles ur
+		INNER JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1
+		ORDER BY r.name
+	`
+
+	rows, err := rr.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := make([]string, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (rr *roleRepo) GetRolesVersion(userID int64) (int64, error) {
+	query := `SELECT COALESCE((SELECT version FROM user_roles_version WHERE user_id = $1), 0)`
+
+	var version int64
+	err := rr.db.QueryRow(query, userID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,44 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/bxcodec/faker/v4"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignRole(t *testing.T) {
+	u := createUser(t)
+
+	role, err := strg.Role().Create(&repo.Role{
+		Name: faker.Word() + faker.UUIDDigit()[:8],
+	})
+	require.NoError(t, err)
+
+	roles, err := strg.Role().GetUserRoles(u.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{repo.UserTypeUser}, roles)
+
+	version, err := strg.Role().GetRolesVersion(u.ID)
+	require.NoError(t, err)
+
+	err = strg.Role().AssignRole(u.ID, role.Name)
+	require.NoError(t, err)
+
+	newVersion, err := strg.Role().GetRolesVersion(u.ID)
+	require.NoError(t, err)
+	require.Greater(t, newVersion, version)
+
+	roles, err = strg.Role().GetUserRoles(u.ID)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+
+	err = strg.Role().RevokeRole(u.ID, role.Name)
+	require.NoError(t, err)
+
+	err = strg.Role().Delete(role.ID)
+	require.NoError(t, err)
+
+	deleteUser(u.ID, t)
+}
@@ -1,12 +1,17 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ibrat-muslim/blog_app_user_service/pkg/utils"
 	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type userRepo struct {
@@ -21,18 +26,25 @@ func NewUser(db *sqlx.DB) repo.UserStorageI {
 
 func (ur *userRepo) Create(user *repo.User) (*repo.User, error) {
 	query := `
-		INSERT INTO users (
-			first_name,
-			last_name,
-			phone_number,
-			email,
-			gender,
-			password,
-			username,
-			profile_image_url,
-			type
-		) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, created_at
+		WITH u AS (
+			INSERT INTO users (
+				first_name,
+				last_name,
+				phone_number,
+				email,
+				gender,
+				password,
+				username,
+				profile_image_url,
+				type,
+				verified
+			) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id, created_at
+		), ur AS (
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT u.id, r.id FROM u, roles r WHERE r.name = $9
+		)
+		SELECT id, created_at FROM u
 	`
 
 	row := ur.db.QueryRow(
@@ -46,6 +58,7 @@ func (ur *userRepo) Create(user *repo.User) (*repo.User, error) {
 		utils.NullString(user.Username),
 		utils.NullString(user.ProfileImageUrl),
 		user.Type,
+		user.Verified,
 	)
 
 	err := row.Scan(
@@ -77,9 +90,12 @@ func (ur *userRepo) Get(id int64) (*repo.User, error) {
 			username,
 			profile_image_url,
 			type,
+			verified,
+			popularity,
+			username_changed_at,
 			created_at
 		FROM users
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	row := ur.db.QueryRow(query, id)
@@ -94,6 +110,9 @@ func (ur *userRepo) Get(id int64) (*repo.User, error) {
 		&username,
 		&profileImageUrl,
 		&result.Type,
+		&result.Verified,
+		&result.Popularity,
+		&result.UsernameChangedAt,
 		&result.CreatedAt,
 	)
 	if err != nil {
@@ -126,9 +145,12 @@ func (ur *userRepo) GetByEmail(email string) (*repo.User, error) {
 			username,
 			profile_image_url,
 			type,
+			verified,
+			popularity,
+			username_changed_at,
 			created_at
 		FROM users
-		WHERE email = $1
+		WHERE email = $1 AND deleted_at IS NULL
 	`
 
 	row := ur.db.QueryRow(query, email)
@@ -143,6 +165,9 @@ func (ur *userRepo) GetByEmail(email string) (*repo.User, error) {
 		&username,
 		&profileImageUrl,
 		&result.Type,
+		&result.Verified,
+		&result.Popularity,
+		&result.UsernameChangedAt,
 		&result.CreatedAt,
 	)
 	if err != nil {
@@ -163,19 +188,34 @@ func (ur *userRepo) GetAll(params *repo.GetUsersParams) (*repo.GetUsersResult, e
 		Count: 0,
 	}
 
-	offset := (params.Page - 1) * params.Limit
+	filter, args := usersFilter(params)
 
-	limit := fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+	var (
+		where, orderBy, limit string
+		whereArgs             = args
+		err                   error
+	)
 
-	filter := ""
+	keyset := params.Page == 0 && len(params.Sort) == 0
 
-	if params.Search != "" {
-		str := "%" + params.Search + "%"
-		filter += fmt.Sprintf(`
-				WHERE first_name ILIKE '%s' OR last_name ILIKE '%s' OR phone_number ILIKE '%s' 
-				OR email ILIKE '%s' OR username ILIKE '%s'`,
-			str, str, str, str, str,
-		)
+	if keyset {
+		where, whereArgs, orderBy = usersKeyset(filter, args, params.Cursor)
+		// one more row tells whether there is a page after this one
+		limit = fmt.Sprintf(" LIMIT %d ", params.Limit+1)
+	} else {
+		page := params.Page
+		if page < 1 {
+			page = 1
+		}
+
+		offset := (page - 1) * params.Limit
+		limit = fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+
+		where = filter
+		orderBy, err = usersOrderBy(params.Sort)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	query := `
@@ -190,13 +230,16 @@ func (ur *userRepo) GetAll(params *repo.GetUsersParams) (*repo.GetUsersResult, e
 			username,
 			profile_image_url,
 			type,
+			verified,
+			popularity,
+			username_changed_at,
 			created_at
 		FROM users
-		` + filter + `
-		ORDER BY created_at DESC
+		` + where + `
+		` + orderBy + `
 		` + limit
 
-	rows, err := ur.db.Query(query)
+	rows, err := ur.db.Query(query, whereArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +263,9 @@ func (ur *userRepo) GetAll(params *repo.GetUsersParams) (*repo.GetUsersResult, e
 			&username,
 			&profileImageUrl,
 			&user.Type,
+			&user.Verified,
+			&user.Popularity,
+			&user.UsernameChangedAt,
 			&user.CreatedAt,
 		)
 		if err != nil {
@@ -234,54 +280,723 @@ func (ur *userRepo) GetAll(params *repo.GetUsersParams) (*repo.GetUsersResult, e
 		result.Users = append(result.Users, &user)
 	}
 
-	queryCount := `SELECT count(1) FROM users ` + filter
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if keyset {
+		usersPage(&result, params.Limit, params.Cursor)
+	}
+
+	if !params.SkipCount {
+		queryCount := `SELECT count(1) FROM users ` + filter
+
+		err = ur.db.QueryRow(queryCount, args...).Scan(&result.Count)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+func (ur *userRepo) GetByIDs(ids []int64, usernames []string) ([]*repo.User, error) {
+	result := make([]*repo.User, 0, len(ids)+len(usernames))
+
+	lower := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		lower = append(lower, strings.ToLower(username))
+	}
+
+	query := `
+		SELECT
+			id,
+			first_name,
+			last_name,
+			phone_number,
+			email,
+			gender,
+			password,
+			username,
+			profile_image_url,
+			type,
+			verified,
+			popularity,
+			username_changed_at,
+			created_at
+		FROM users
+		WHERE deleted_at IS NULL AND (id = ANY($1) OR lower(username) = ANY($2))
+	`
 
-	err = ur.db.QueryRow(queryCount).Scan(&result.Count)
+	rows, err := ur.db.Query(query, pq.Array(ids), pq.Array(lower))
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			user                                           repo.User
+			phoneNumber, gender, username, profileImageUrl sql.NullString
+		)
+
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&phoneNumber,
+			&user.Email,
+			&gender,
+			&user.Password,
+			&username,
+			&profileImageUrl,
+			&user.Type,
+			&user.Verified,
+			&user.Popularity,
+			&user.UsernameChangedAt,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		user.PhoneNumber = phoneNumber.String
+		user.Gender = gender.String
+		user.Username = username.String
+		user.ProfileImageUrl = profileImageUrl.String
+
+		result = append(result, &user)
+	}
+
+	return result, rows.Err()
+}
+
+func (ur *userRepo) TakenUsernames(usernames []string) ([]string, error) {
+	lower := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		lower = append(lower, strings.ToLower(username))
+	}
+
+	query := `SELECT lower(username) FROM users WHERE lower(username) = ANY($1)`
+
+	rows, err := ur.db.Query(query, pq.Array(lower))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	taken := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		taken = append(taken, username)
+	}
+
+	return taken, rows.Err()
+}
+
+func (ur *userRepo) Search(params *repo.SearchUsersParams) ([]*repo.UserSearchResult, error) {
+	result := make([]*repo.UserSearchResult, 0)
+
+	tsQuery := searchTSQuery(params.Query)
+	if tsQuery == "" {
+		return result, nil
+	}
+
+	offset := (params.Page - 1) * params.Limit
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit := fmt.Sprintf(" LIMIT %d OFFSET %d ", params.Limit, offset)
+
+	query := `
+		WITH q AS (SELECT to_tsquery('simple', $1) AS query)
+		SELECT
+			u.id,
+			u.first_name,
+			u.last_name,
+			u.phone_number,
+			u.email,
+			u.gender,
+			u.password,
+			u.username,
+			u.profile_image_url,
+			u.type,
+			u.verified,
+			u.popularity,
+			u.username_changed_at,
+			u.created_at,
+			ts_rank(u.search_vector, q.query) + word_similarity($2, u.search_text) AS rank,
+			ts_headline(
+				'simple',
+				u.first_name || ' ' || u.last_name || coalesce(' ' || u.username, '') || ' ' || u.email,
+				q.query,
+				'StartSel=<b>, StopSel=</b>, HighlightAll=true'
+			) AS highlight
+		FROM users u, q
+		WHERE u.deleted_at IS NULL AND (u.search_vector @@ q.query OR $2 <% u.search_text)
+		ORDER BY rank DESC, u.id
+		` + limit
+
+	rows, err := ur.db.Query(query, tsQuery, strings.ToLower(strings.TrimSpace(params.Query)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			hit                                            repo.UserSearchResult
+			user                                           repo.User
+			phoneNumber, gender, username, profileImageUrl sql.NullString
+		)
+
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&phoneNumber,
+			&user.Email,
+			&gender,
+			&user.Password,
+			&username,
+			&profileImageUrl,
+			&user.Type,
+			&user.Verified,
+			&user.Popularity,
+			&user.UsernameChangedAt,
+			&user.CreatedAt,
+			&hit.Rank,
+			&hit.Highlight,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		user.PhoneNumber = phoneNumber.String
+		user.Gender = gender.String
+		user.Username = username.String
+		user.ProfileImageUrl = profileImageUrl.String
+
+		hit.User = &user
+		result = append(result, &hit)
+	}
+
+	return result, rows.Err()
+}
+
+var tsQueryQuotes = strings.NewReplacer("'", "", "\\", "")
+
+// searchTSQuery turns the words of a search into a tsquery matching all of
+// them as prefixes. Every word is quoted so it can not inject operators.
+func searchTSQuery(search string) string {
+	terms := make([]string, 0)
+
+	for _, word := range strings.Fields(search) {
+		word = tsQueryQuotes.Replace(word)
+		if word == "" {
+			continue
+		}
+
+		terms = append(terms, "'"+word+"':*")
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// exportBatch is the number of rows fetched from the export cursor at once
+const exportBatch = 1000
+
+func (ur *userRepo) Export(params *repo.GetUsersParams, fn func(user *repo.User) error) error {
+	tx, err := ur.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	// the cursor is closed with the transaction
+	defer tx.Rollback()
+
+	filter, args := usersFilter(params)
+
+	query := `
+		DECLARE users_export NO SCROLL CURSOR FOR
+		SELECT
+			id,
+			first_name,
+			last_name,
+			phone_number,
+			email,
+			gender,
+			username,
+			profile_image_url,
+			type,
+			verified,
+			popularity,
+			username_changed_at,
+			created_at
+		FROM users
+		` + filter + `
+		ORDER BY id
+	`
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+
+	for {
+		count, err := fetchExport(tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if count < exportBatch {
+			return nil
+		}
+	}
+}
+
+// fetchExport passes the next batch of the export cursor to fn and returns its size
+func fetchExport(tx *sql.Tx, fn func(user *repo.User) error) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf(`FETCH %d FROM users_export`, exportBatch))
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var (
+			user                                           repo.User
+			phoneNumber, gender, username, profileImageUrl sql.NullString
+		)
+
+		err := rows.Scan(
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&phoneNumber,
+			&user.Email,
+			&gender,
+			&username,
+			&profileImageUrl,
+			&user.Type,
+			&user.Verified,
+			&user.Popularity,
+			&user.UsernameChangedAt,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			return count, err
+		}
+
+		user.PhoneNumber = phoneNumber.String
+		user.Gender = gender.String
+		user.Username = username.String
+		user.ProfileImageUrl = profileImageUrl.String
+
+		if err := fn(&user); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// usersKeyset narrows the filter to the users after the cursor, or before
+// it for a backward cursor, and orders them away from the cursor
+func usersKeyset(filter string, args []interface{}, cursor *repo.UserCursor) (string, []interface{}, string) {
+	if cursor == nil {
+		return filter, args, " ORDER BY created_at DESC, id DESC "
+	}
+
+	operator, orderBy := "<", " ORDER BY created_at DESC, id DESC "
+	if cursor.Backward {
+		operator, orderBy = ">", " ORDER BY created_at ASC, id ASC "
+	}
+
+	args = append(args[:len(args):len(args)], cursor.CreatedAt, cursor.ID)
+	filter += fmt.Sprintf(" AND (created_at, id) %s ($%d, $%d) ", operator, len(args)-1, len(args))
+
+	return filter, args, orderBy
 }
 
-func (ur *userRepo) Update(user *repo.User) (*repo.User, error) {
+// usersPage trims the extra row fetched by a keyset query, restores the
+// newest first order of a backward page and sets the adjacent cursors
+func usersPage(result *repo.GetUsersResult, limit int32, cursor *repo.UserCursor) {
+	backward := cursor != nil && cursor.Backward
+
+	more := len(result.Users) > int(limit)
+	if more {
+		result.Users = result.Users[:limit]
+	}
+
+	if backward {
+		for i, j := 0, len(result.Users)-1; i < j; i, j = i+1, j-1 {
+			result.Users[i], result.Users[j] = result.Users[j], result.Users[i]
+		}
+	}
+
+	if len(result.Users) == 0 {
+		return
+	}
+
+	first, last := result.Users[0], result.Users[len(result.Users)-1]
+
+	// going forward there is a previous page behind the cursor, going
+	// backward there is a next page behind it
+	if backward || more {
+		result.Next = &repo.UserCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	if (backward && more) || (!backward && cursor != nil) {
+		result.Prev = &repo.UserCursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true}
+	}
+}
+
+// usersFilter builds the WHERE clause of the params and its arguments
+func usersFilter(params *repo.GetUsersParams) (string, []interface{}) {
+	var (
+		conditions = []string{"deleted_at IS NULL"}
+		args       []interface{}
+	)
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if params.Search != "" {
+		// search_text is lower case and has a trigram index serving LIKE
+		conditions = append(conditions, "search_text LIKE "+arg("%"+strings.ToLower(params.Search)+"%"))
+	}
+
+	if params.OrganizationID != 0 {
+		conditions = append(conditions, fmt.Sprintf(
+			"id IN (SELECT user_id FROM organization_members WHERE organization_id = %s)",
+			arg(params.OrganizationID),
+		))
+	}
+
+	if len(params.IDs) > 0 {
+		conditions = append(conditions, "id = ANY("+arg(pq.Array(params.IDs))+")")
+	}
+
+	if len(params.Types) > 0 {
+		conditions = append(conditions, "type = ANY("+arg(pq.Array(params.Types))+")")
+	}
+
+	if len(params.Roles) > 0 {
+		conditions = append(conditions, fmt.Sprintf(
+			"id IN (SELECT ur.user_id FROM user_roles ur INNER JOIN roles r ON r.id = ur.role_id WHERE r.name = ANY(%s))",
+			arg(pq.Array(params.Roles)),
+		))
+	}
+
+	if params.Gender != "" {
+		conditions = append(conditions, "gender = "+arg(params.Gender))
+	}
+
+	if !params.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(params.CreatedFrom))
+	}
+
+	if !params.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(params.CreatedTo))
+	}
+
+	if params.HasUsername != nil {
+		if *params.HasUsername {
+			conditions = append(conditions, "username IS NOT NULL")
+		} else {
+			conditions = append(conditions, "username IS NULL")
+		}
+	}
+
+	if params.Verified != nil {
+		conditions = append(conditions, "verified = "+arg(*params.Verified))
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ") + " ", args
+}
+
+// usersOrderBy builds the ORDER BY clause, newest first by default.
+// The id breaks ties so pages are stable.
+func usersOrderBy(sort []repo.SortField) (string, error) {
+	if len(sort) == 0 {
+		return " ORDER BY created_at DESC, id DESC ", nil
+	}
+
+	columns := make([]string, 0, len(sort)+1)
+	hasID := false
+
+	for _, field := range sort {
+		if !repo.UserSortableFields[field.Field] {
+			return "", fmt.Errorf("users can not be sorted by %q", field.Field)
+		}
+
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+
+		columns = append(columns, field.Field+" "+direction)
+		hasID = hasID || field.Field == "id"
+	}
+
+	if !hasID {
+		columns = append(columns, "id ASC")
+	}
+
+	return " ORDER BY " + strings.Join(columns, ", ") + " ", nil
+}
+
+func (ur *userRepo) Update(user *repo.User, fields []string) (*repo.User, error) {
+	if len(fields) == 0 {
+		fields = repo.UserUpdatableFields
+	}
+
+	var (
+		columns []string
+		args    []interface{}
+	)
+
+	for _, field := range fields {
+		var value interface{}
+
+		switch field {
+		case "first_name":
+			value = user.FirstName
+		case "last_name":
+			value = user.LastName
+		case "phone_number":
+			value = utils.NullString(user.PhoneNumber)
+		case "gender":
+			value = utils.NullString(user.Gender)
+		case "username":
+			value = utils.NullString(user.Username)
+		case "profile_image_url":
+			value = utils.NullString(user.ProfileImageUrl)
+		default:
+			return nil, fmt.Errorf("field %q can not be updated", field)
+		}
+
+		args = append(args, value)
+		columns = append(columns, fmt.Sprintf("%s = $%d", field, len(args)))
+
+		// SET reads the old username, setting a different one starts the cool-down
+		if field == "username" {
+			columns = append(columns, fmt.Sprintf(
+				"username_changed_at = CASE WHEN $%d IS NOT NULL AND username IS DISTINCT FROM $%d THEN CURRENT_TIMESTAMP ELSE username_changed_at END",
+				len(args), len(args),
+			))
+		}
+	}
+
+	args = append(args, user.ID)
+
 	query := `
 		UPDATE users SET
-			first_name = $1,
-			last_name = $2,
-			phone_number = $3,
-			gender = $4,
-			username = $5,
-			profile_image_url = $6
-		WHERE id = $7
-		RETURNING email, type, created_at
+			` + strings.Join(columns, ", ") + `
+		WHERE id = $` + fmt.Sprint(len(args)) + ` AND deleted_at IS NULL
+		RETURNING
+			first_name,
+			last_name,
+			phone_number,
+			email,
+			gender,
+			username,
+			profile_image_url,
+			type,
+			verified,
+			popularity,
+			username_changed_at,
+			created_at
 	`
 
-	row := ur.db.QueryRow(
+	var phoneNumber, gender, username, profileImageUrl sql.NullString
+
+	err := ur.db.QueryRow(query, args...).Scan(
+		&user.FirstName,
+		&user.LastName,
+		&phoneNumber,
+		&user.Email,
+		&gender,
+		&username,
+		&profileImageUrl,
+		&user.Type,
+		&user.Verified,
+		&user.Popularity,
+		&user.UsernameChangedAt,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.PhoneNumber = phoneNumber.String
+	user.Gender = gender.String
+	user.Username = username.String
+	user.ProfileImageUrl = profileImageUrl.String
+
+	return user, nil
+}
+
+func (ur *userRepo) ImportUsers(users []*repo.User, onDuplicate string, dryRun bool) ([]*repo.ImportResult, error) {
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// a taken email makes the insert return no row when skipping, deleted
+	// users are never updated and the type of updated users is kept. Fields
+	// the file leaves empty keep their value.
+	onConflict := `ON CONFLICT (email) DO NOTHING`
+	if onDuplicate == repo.ImportUpdateDuplicates || onDuplicate == repo.ImportReplaceDuplicates {
+		password := ""
+		if onDuplicate == repo.ImportReplaceDuplicates {
+			password = "password = EXCLUDED.password,"
+		}
+
+		onConflict = `
+			ON CONFLICT (email) DO UPDATE SET
+				first_name = EXCLUDED.first_name,
+				last_name = EXCLUDED.last_name,
+				phone_number = COALESCE(EXCLUDED.phone_number, users.phone_number),
+				gender = COALESCE(EXCLUDED.gender, users.gender),
+				` + password + `
+				username = COALESCE(EXCLUDED.username, users.username),
+				username_changed_at = CASE WHEN EXCLUDED.username IS NOT NULL AND users.username IS DISTINCT FROM EXCLUDED.username THEN CURRENT_TIMESTAMP ELSE users.username_changed_at END
+			WHERE users.deleted_at IS NULL
+		`
+	}
+
+	query := `
+		INSERT INTO users (
+			first_name,
+			last_name,
+			phone_number,
+			email,
+			gender,
+			password,
+			username,
+			type,
+			verified
+		) VALUES($1, $2, $3, $4, $5, $6, $7, $8, true)
+		` + onConflict + `
+		RETURNING id, created_at, xmax = 0
+	`
+
+	queryRole := `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING
+	`
+
+	results := make([]*repo.ImportResult, 0, len(users))
+
+	for _, user := range users {
+		if _, err := tx.Exec(`SAVEPOINT import_row`); err != nil {
+			return nil, err
+		}
+
+		result, err := importUser(tx, query, queryRole, user)
+		if err != nil {
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT import_row`); err != nil {
+				return nil, err
+			}
+
+			results = append(results, &repo.ImportResult{Err: err})
+			continue
+		}
+
+		if _, err := tx.Exec(`RELEASE SAVEPOINT import_row`); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	if dryRun {
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func importUser(tx *sql.Tx, query, queryRole string, user *repo.User) (*repo.ImportResult, error) {
+	var inserted bool
+
+	err := tx.QueryRow(
 		query,
 		user.FirstName,
 		user.LastName,
 		utils.NullString(user.PhoneNumber),
+		user.Email,
 		utils.NullString(user.Gender),
+		user.Password,
 		utils.NullString(user.Username),
-		utils.NullString(user.ProfileImageUrl),
-		user.ID,
-	)
-
-	err := row.Scan(
-		&user.Email,
-		&user.Type,
+		user.Type,
+	).Scan(
+		&user.ID,
 		&user.CreatedAt,
+		&inserted,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &repo.ImportResult{Outcome: repo.ImportSkipped}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	if !inserted {
+		return &repo.ImportResult{Outcome: repo.ImportUpdated}, nil
+	}
+
+	if _, err := tx.Exec(queryRole, user.ID, user.Type); err != nil {
+		return nil, err
+	}
+
+	user.Verified = true
+	return &repo.ImportResult{Outcome: repo.ImportCreated}, nil
+}
+
+func (ur *userRepo) IncrementPopularity(id, delta int64) error {
+	query := `UPDATE users SET popularity = popularity + $1 WHERE id = $2 AND deleted_at IS NULL`
+
+	result, err := ur.db.Exec(query, delta, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
 
+// Delete marks the user deleted, Restore undoes it until the user is purged
 func (ur *userRepo) Delete(id int64) error {
-	query := `DELETE FROM users WHERE id = $1`
+	query := `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL`
 
 	resutl, err := ur.db.Exec(query, id)
 	if err != nil {
@@ -301,7 +1016,7 @@ func (ur *userRepo) Delete(id int64) error {
 }
 
 func (ur *userRepo) UpdatePassword(req *repo.UpdatePassword) error {
-	query := `UPDATE users SET password = $1 WHERE id = $2`
+	query := `UPDATE users SET password = $1 WHERE id = $2 AND deleted_at IS NULL`
 
 	_, err := ur.db.Exec(
 		query,
@@ -314,3 +1029,125 @@ func (ur *userRepo) UpdatePassword(req *repo.UpdatePassword) error {
 
 	return nil
 }
+
+func (ur *userRepo) Restore(id int64) error {
+	query := `
+		UPDATE users SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL AND NOT anonymized
+	`
+
+	result, err := ur.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Purge removes the deleted user permanently without waiting for the grace period
+func (ur *userRepo) Purge(id int64) error {
+	query := `DELETE FROM users WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	result, err := ur.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsCount == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (ur *userRepo) PurgeDeleted(before time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE deleted_at < $1`
+
+	result, err := ur.db.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// AnonymizeDeleted replaces the personal data of the users deleted before
+// the time, keeping their ids for the records that refer to them. Their
+// login events lose the email, address and location, the invitations sent
+// to their email are removed.
+func (ur *userRepo) AnonymizeDeleted(before time.Time) (int64, error) {
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	deleted := `SELECT id, lower(email) FROM users WHERE deleted_at < $1 AND NOT anonymized`
+
+	queryEvents := `
+		UPDATE login_events SET
+			email = 'deleted-' || d.id || '@anonymized.invalid',
+			ip_address = NULL,
+			user_agent = NULL,
+			country = NULL,
+			asn = NULL,
+			latitude = NULL,
+			longitude = NULL
+		FROM (` + deleted + `) d(id, email)
+		WHERE login_events.user_id = d.id OR lower(login_events.email) = d.email
+	`
+
+	if _, err := tx.Exec(queryEvents, before); err != nil {
+		return 0, err
+	}
+
+	queryInvitations := `
+		DELETE FROM organization_invitations
+		WHERE lower(email) IN (SELECT email FROM (` + deleted + `) d(id, email))
+	`
+
+	if _, err := tx.Exec(queryInvitations, before); err != nil {
+		return 0, err
+	}
+
+	query := `
+		UPDATE users SET
+			first_name = 'Deleted',
+			last_name = 'User',
+			phone_number = NULL,
+			email = 'deleted-' || id || '@anonymized.invalid',
+			gender = NULL,
+			password = '',
+			username = NULL,
+			profile_image_url = NULL,
+			anonymized = true
+		WHERE deleted_at < $1 AND NOT anonymized
+	`
+
+	result, err := tx.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsCount, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsCount, tx.Commit()
+}
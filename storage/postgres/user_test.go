@@ -1,7 +1,12 @@
 package postgres_test
 
 import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bxcodec/faker/v4"
 	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
@@ -24,7 +29,13 @@ func createUser(t *testing.T) *repo.User {
 }
 
 func deleteUser(id int64, t *testing.T) {
+	// only deleted users can be purged
 	err := strg.User().Delete(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		require.NoError(t, err)
+	}
+
+	err = strg.User().Purge(id)
 	require.NoError(t, err)
 }
 
@@ -69,6 +80,224 @@ func TestGetAllUsers(t *testing.T) {
 	deleteUser(u.ID, t)
 }
 
+func TestGetAllUsersFilters(t *testing.T) {
+	u := createUser(t)
+	hasUsername := false
+
+	users, err := strg.User().GetAll(&repo.GetUsersParams{
+		Limit:       10,
+		Page:        1,
+		Search:      u.Email,
+		IDs:         []int64{u.ID},
+		Roles:       []string{repo.UserTypeUser},
+		CreatedFrom: u.CreatedAt.Add(-time.Minute),
+		HasUsername: &hasUsername,
+		Sort:        []repo.SortField{{Field: "last_name"}, {Field: "created_at", Desc: true}},
+	})
+	require.NoError(t, err)
+	require.Len(t, users.Users, 1)
+	require.Equal(t, int32(1), users.Count)
+
+	_, err = strg.User().GetAll(&repo.GetUsersParams{
+		Limit: 10,
+		Page:  1,
+		Sort:  []repo.SortField{{Field: "password"}},
+	})
+	require.Error(t, err)
+
+	deleteUser(u.ID, t)
+}
+
+func TestGetAllUsersCursor(t *testing.T) {
+	u1 := createUser(t)
+	u2 := createUser(t)
+	u3 := createUser(t)
+
+	params := repo.GetUsersParams{
+		Limit:     2,
+		IDs:       []int64{u1.ID, u2.ID, u3.ID},
+		SkipCount: true,
+	}
+
+	first, err := strg.User().GetAll(&params)
+	require.NoError(t, err)
+	require.Len(t, first.Users, 2)
+	require.Equal(t, u3.ID, first.Users[0].ID)
+	require.Equal(t, int32(0), first.Count)
+	require.NotNil(t, first.Next)
+	require.Nil(t, first.Prev)
+
+	params.Cursor = first.Next
+	second, err := strg.User().GetAll(&params)
+	require.NoError(t, err)
+	require.Len(t, second.Users, 1)
+	require.Equal(t, u1.ID, second.Users[0].ID)
+	require.Nil(t, second.Next)
+	require.NotNil(t, second.Prev)
+
+	params.Cursor = second.Prev
+	back, err := strg.User().GetAll(&params)
+	require.NoError(t, err)
+	require.Len(t, back.Users, 2)
+	require.Equal(t, u3.ID, back.Users[0].ID)
+	require.Equal(t, u2.ID, back.Users[1].ID)
+	require.Nil(t, back.Prev)
+
+	deleteUser(u1.ID, t)
+	deleteUser(u2.ID, t)
+	deleteUser(u3.ID, t)
+}
+
+func TestGetUsersByIDs(t *testing.T) {
+	u1 := createUser(t)
+	u2 := createUser(t)
+
+	users, err := strg.User().GetByIDs([]int64{u1.ID, u2.ID, -1}, []string{"no-such-user"})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	name := "batch_" + strconv.FormatInt(u1.ID, 10)
+	_, err = strg.User().Update(&repo.User{ID: u1.ID, Username: name}, []string{"username"})
+	require.NoError(t, err)
+
+	// usernames match in any case
+	users, err = strg.User().GetByIDs(nil, []string{strings.ToUpper(name)})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, u1.ID, users[0].ID)
+
+	deleteUser(u1.ID, t)
+	deleteUser(u2.ID, t)
+}
+
+func TestUsernames(t *testing.T) {
+	u1 := createUser(t)
+	u2 := createUser(t)
+
+	name := "user_" + strconv.FormatInt(u1.ID, 10)
+
+	user, err := strg.User().Update(&repo.User{ID: u1.ID, Username: name}, []string{"username"})
+	require.NoError(t, err)
+	require.NotNil(t, user.UsernameChangedAt)
+
+	taken, err := strg.User().TakenUsernames([]string{strings.ToUpper(name), "no-such-user"})
+	require.NoError(t, err)
+	require.Equal(t, []string{name}, taken)
+
+	// usernames are unique in any case
+	_, err = strg.User().Update(&repo.User{ID: u2.ID, Username: strings.ToUpper(name)}, []string{"username"})
+	require.Error(t, err)
+
+	deleteUser(u1.ID, t)
+	deleteUser(u2.ID, t)
+}
+
+func TestImportUsers(t *testing.T) {
+	existing := createUser(t)
+
+	newUser := func() *repo.User {
+		return &repo.User{
+			FirstName: faker.FirstName(),
+			LastName:  faker.LastName(),
+			Email:     faker.Email(),
+			Password:  faker.Password(),
+			Type:      repo.UserTypeUser,
+		}
+	}
+
+	dryRunUser := newUser()
+	results, err := strg.User().ImportUsers([]*repo.User{dryRunUser}, repo.ImportSkipDuplicates, true)
+	require.NoError(t, err)
+	require.Equal(t, repo.ImportCreated, results[0].Outcome)
+
+	_, err = strg.User().GetByEmail(dryRunUser.Email)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	created := newUser()
+	duplicate := newUser()
+	duplicate.Email = existing.Email
+	invalid := newUser()
+	invalid.Gender = "unknown"
+
+	results, err = strg.User().ImportUsers([]*repo.User{created, duplicate, invalid}, repo.ImportSkipDuplicates, false)
+	require.NoError(t, err)
+	require.Equal(t, repo.ImportCreated, results[0].Outcome)
+	require.Equal(t, repo.ImportSkipped, results[1].Outcome)
+	require.Error(t, results[2].Err)
+
+	results, err = strg.User().ImportUsers([]*repo.User{duplicate}, repo.ImportUpdateDuplicates, false)
+	require.NoError(t, err)
+	require.Equal(t, repo.ImportUpdated, results[0].Outcome)
+
+	user, err := strg.User().Get(existing.ID)
+	require.NoError(t, err)
+	require.Equal(t, duplicate.FirstName, user.FirstName)
+	require.Equal(t, existing.Password, user.Password)
+
+	results, err = strg.User().ImportUsers([]*repo.User{duplicate}, repo.ImportReplaceDuplicates, false)
+	require.NoError(t, err)
+	require.Equal(t, repo.ImportUpdated, results[0].Outcome)
+
+	user, err = strg.User().Get(existing.ID)
+	require.NoError(t, err)
+	require.Equal(t, duplicate.Password, user.Password)
+
+	deleteUser(existing.ID, t)
+	deleteUser(created.ID, t)
+}
+
+func TestExportUsers(t *testing.T) {
+	u1 := createUser(t)
+	u2 := createUser(t)
+
+	exported := make([]*repo.User, 0)
+	err := strg.User().Export(&repo.GetUsersParams{IDs: []int64{u1.ID, u2.ID}}, func(user *repo.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+	require.Equal(t, u1.ID, exported[0].ID)
+	require.Empty(t, exported[0].Password)
+
+	deleteUser(u1.ID, t)
+	deleteUser(u2.ID, t)
+}
+
+func TestSearchUsers(t *testing.T) {
+	u := createUser(t)
+
+	// prefix of the last name
+	results, err := strg.User().Search(&repo.SearchUsersParams{
+		Query: u.FirstName + " " + u.LastName[:len(u.LastName)-1],
+		Limit: 10,
+		Page:  1,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	require.Equal(t, u.ID, results[0].User.ID)
+	require.Contains(t, results[0].Highlight, "<b>")
+
+	// operators are searched as text
+	_, err = strg.User().Search(&repo.SearchUsersParams{Query: "a & !b | c:* '", Limit: 10, Page: 1})
+	require.NoError(t, err)
+
+	deleteUser(u.ID, t)
+}
+
+func TestIncrementPopularity(t *testing.T) {
+	u := createUser(t)
+
+	err := strg.User().IncrementPopularity(u.ID, 3)
+	require.NoError(t, err)
+
+	user, err := strg.User().Get(u.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), user.Popularity)
+
+	deleteUser(u.ID, t)
+}
+
 func TestUpdateUser(t *testing.T) {
 	u := createUser(t)
 
@@ -78,13 +307,58 @@ func TestUpdateUser(t *testing.T) {
 	u.Password = faker.Password()
 	u.Type = repo.UserTypeUser
 
-	user, err := strg.User().Update(u)
+	user, err := strg.User().Update(u, nil)
 	require.NoError(t, err)
 	require.NotEmpty(t, user)
 
 	deleteUser(user.ID, t)
 }
 
+func TestUpdateUserFields(t *testing.T) {
+	u := createUser(t)
+
+	user, err := strg.User().Update(&repo.User{
+		ID:        u.ID,
+		FirstName: faker.FirstName(),
+	}, []string{"first_name"})
+	require.NoError(t, err)
+	require.Equal(t, u.LastName, user.LastName)
+	require.Equal(t, u.Email, user.Email)
+
+	user, err = strg.User().Update(&repo.User{ID: u.ID}, []string{"phone_number"})
+	require.NoError(t, err)
+	require.Empty(t, user.PhoneNumber)
+
+	_, err = strg.User().Update(&repo.User{ID: u.ID}, []string{"email"})
+	require.Error(t, err)
+
+	deleteUser(u.ID, t)
+}
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	u := createUser(t)
+
+	err := strg.User().Delete(u.ID)
+	require.NoError(t, err)
+
+	_, err = strg.User().Get(u.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = strg.User().GetByEmail(u.Email)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = strg.User().Restore(u.ID)
+	require.NoError(t, err)
+
+	_, err = strg.User().Get(u.ID)
+	require.NoError(t, err)
+
+	err = strg.User().Restore(u.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	deleteUser(u.ID, t)
+}
+
 func TestUpdatePassword(t *testing.T) {
 	u := createUser(t)
 
@@ -100,5 +374,9 @@ func TestUpdatePassword(t *testing.T) {
 
 func TestDeleteUser(t *testing.T) {
 	u := createUser(t)
+
+	err := strg.User().Purge(u.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
 	deleteUser(u.ID, t)
 }
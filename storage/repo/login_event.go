@@ -0,0 +1,45 @@
+package repo
+
+import "time"
+
+const (
+	LoginEventTypeLogin         = "login"
+	LoginEventTypePasswordReset = "password_reset"
+	LoginEventTypeTokenRefresh  = "token_refresh"
+)
+
+type LoginEvent struct {
+	ID        int64
+	UserID    int64
+	Email     string
+	Type      string
+	Success   bool
+	IPAddress string
+	UserAgent string
+	Reason    string
+	Country   string
+	ASN       int64
+	Latitude  float64
+	Longitude float64
+	RiskScore int32
+	CreatedAt time.Time
+}
+
+type GetLoginEventsParams struct {
+	UserID int64
+	Limit  int32
+	Page   int32
+	From   time.Time
+	To     time.Time
+}
+
+type GetLoginEventsResult struct {
+	Events []*LoginEvent
+	Count  int32
+}
+
+type LoginEventStorageI interface {
+	Create(event *LoginEvent) (*LoginEvent, error)
+	GetAll(params *GetLoginEventsParams) (*GetLoginEventsResult, error)
+	GetRecentSuccessful(userID int64, limit int) ([]*LoginEvent, error)
+}
@@ -0,0 +1,6 @@
+package repo
+
+type NotificationStorageI interface {
+	GetDisabledTypes(userID int64) ([]string, error)
+	SetDisabledTypes(userID int64, types []string) error
+}
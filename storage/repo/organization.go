@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"errors"
+	"time"
+)
+
+// OrganizationOwner is the role the creator of an organization gets in it
+const OrganizationOwner = "owner"
+
+// ErrLastOwner is returned when removing a member would leave the
+// organization without an owner
+var ErrLastOwner = errors.New("organization has no other owner")
+
+type Organization struct {
+	ID        int64
+	Name      string
+	Slug      string
+	CreatedAt time.Time
+}
+
+type GetOrganizationsParams struct {
+	Limit  int32
+	Page   int32
+	Search string
+	// UserID limits the result to the organizations the user is a member of
+	UserID int64
+}
+
+type GetOrganizationsResult struct {
+	Organizations []*Organization
+	Count         int32
+}
+
+type OrganizationMember struct {
+	UserID int64
+	Roles  []string
+}
+
+type OrganizationInvitation struct {
+	ID             int64
+	OrganizationID int64
+	Email          string
+	Role           string
+	Token          string
+	InvitedBy      int64
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+type OrganizationStorageI interface {
+	// Create creates the organization with owner as its first member
+	Create(organization *Organization, owner int64) (*Organization, error)
+	Get(id int64) (*Organization, error)
+	GetAll(params *GetOrganizationsParams) (*GetOrganizationsResult, error)
+	Update(organization *Organization) (*Organization, error)
+	Delete(id int64) error
+	AddMember(organizationID, userID int64, role string) error
+	// RemoveMember revokes the role of the member, or the whole membership if
+	// role is empty. It fails with ErrLastOwner instead of removing the last owner.
+	RemoveMember(organizationID, userID int64, role string) error
+	GetMembers(organizationID int64) ([]*OrganizationMember, error)
+	GetMemberRoles(organizationID, userID int64) ([]string, error)
+	CreateInvitation(invitation *OrganizationInvitation) (*OrganizationInvitation, error)
+	GetInvitation(token string) (*OrganizationInvitation, error)
+	// AcceptInvitation marks the invitation accepted and adds the user to the organization
+	AcceptInvitation(id, userID int64) error
+}
@@ -1,5 +1,45 @@
 package repo
 
+const (
+	PermissionAllow = "allow"
+	PermissionDeny  = "deny"
+)
+
+type Permission struct {
+	ID       int64
+	UserType string
+	Resource string
+	Action   string
+	// Condition limits the permission to the resources it holds for,
+	// an empty condition grants the permission on every resource
+	Condition string
+	// Effect is PermissionAllow or PermissionDeny, a matching deny
+	// overrides every allow
+	Effect string
+}
+
+type GetPermissionsParams struct {
+	UserType string
+	Resource string
+}
+
+type GetPermissionsResult struct {
+	Permissions []*Permission
+	Count       int32
+}
+
 type PermissionStorageI interface {
-	CheckPermission(userType, resource, action string) (bool, error)
+	// GetMatching returns the permissions of the roles whose resource
+	// and action patterns cover the action on the resource
+	GetMatching(roles []string, resource, action string) ([]*Permission, error)
+	Create(permission *Permission) (*Permission, error)
+	Delete(id int64) error
+	GetAll(params *GetPermissionsParams) (*GetPermissionsResult, error)
+	Replace(userType string, permissions []*Permission) error
+	// Sync creates the missing roles, updates the descriptions of the others and
+	// adds and removes the permissions in a single transaction
+	Sync(roles []*Role, added, removed []*Permission) error
+	// GetVersion returns the version of the permission set,
+	// it grows on every change of the permissions
+	GetVersion() (int64, error)
 }
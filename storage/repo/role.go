@@ -0,0 +1,36 @@
+package repo
+
+import "time"
+
+type Role struct {
+	ID          int64
+	Name        string
+	Description string
+	CreatedAt   time.Time
+}
+
+type GetRolesParams struct {
+	Limit  int32
+	Page   int32
+	Search string
+}
+
+type GetRolesResult struct {
+	Roles []*Role
+	Count int32
+}
+
+type RoleStorageI interface {
+	Create(role *Role) (*Role, error)
+	Get(id int64) (*Role, error)
+	GetAll(params *GetRolesParams) (*GetRolesResult, error)
+	Update(role *Role) (*Role, error)
+	Delete(id int64) error
+	AssignRole(userID int64, role string) error
+	RevokeRole(userID int64, role string) error
+	GetUserRoles(userID int64) ([]string, error)
+	// GetRolesVersion returns a counter that moves whenever the roles or
+	// the organization memberships of the user change, or the user is
+	// deleted or restored
+	GetRolesVersion(userID int64) (int64, error)
+}
@@ -2,34 +2,126 @@ package repo
 
 import "time"
 
+// Built-in roles, every other role is created at runtime
 const (
 	UserTypeSuperAdmin = "superadmin"
 	UserTypeUser       = "user"
 )
 
 type User struct {
-	ID              int64
-	FirstName       string
-	LastName        string
-	PhoneNumber     string
-	Email           string
-	Gender          string
-	Password        string
-	Username        string
-	ProfileImageUrl string
-	Type            string
-	CreatedAt       time.Time
+	ID                int64
+	FirstName         string
+	LastName          string
+	PhoneNumber       string
+	Email             string
+	Gender            string
+	Password          string
+	Username          string
+	ProfileImageUrl   string
+	Type              string
+	Verified          bool
+	Popularity        int64
+	UsernameChangedAt *time.Time
+	CreatedAt         time.Time
+}
+
+// UserUpdatableFields are the columns Update may write
+var UserUpdatableFields = []string{
+	"first_name",
+	"last_name",
+	"phone_number",
+	"gender",
+	"username",
+	"profile_image_url",
+}
+
+// UserSortableFields are the columns users can be sorted by
+var UserSortableFields = map[string]bool{
+	"id":         true,
+	"first_name": true,
+	"last_name":  true,
+	"email":      true,
+	"username":   true,
+	"created_at": true,
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// UserCursor is a position in the users ordered by creation, newest first
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        int64
+	// Backward lists the users before the position instead of after it
+	Backward bool
 }
 
 type GetUsersParams struct {
-	Limit  int32
+	Limit int32
+	// Page uses OFFSET pagination when set, otherwise the users are listed
+	// from the Cursor. Cursors only apply to the default order.
 	Page   int32
+	Cursor *UserCursor
 	Search string
+	// OrganizationID limits the result to the members of the organization
+	OrganizationID int64
+
+	IDs         []int64
+	Types       []string
+	Roles       []string
+	Gender      string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	HasUsername *bool
+	Verified    *bool
+	Sort        []SortField
+	// SkipCount leaves Count empty to spare the count query
+	SkipCount bool
 }
 
 type GetUsersResult struct {
 	Users []*User
 	Count int32
+	// Next and Prev are the cursors of the adjacent pages, nil if there is none
+	Next *UserCursor
+	Prev *UserCursor
+}
+
+type SearchUsersParams struct {
+	Query string
+	Limit int32
+	Page  int32
+}
+
+// UserSearchResult is a user matching a search, best matches rank higher
+type UserSearchResult struct {
+	User *User
+	Rank float64
+	// Highlight is the name, username and email with the matched words in <b> tags
+	Highlight string
+}
+
+// Policies of ImportUsers for rows whose email is taken. Updating keeps
+// the password of the user, replacing overwrites it too.
+const (
+	ImportSkipDuplicates    = "skip"
+	ImportUpdateDuplicates  = "update"
+	ImportReplaceDuplicates = "replace"
+)
+
+// Outcomes of an imported row
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+)
+
+type ImportResult struct {
+	Outcome string
+	// Err is the reason the database rejected the row, Outcome is empty then
+	Err error
 }
 
 type UpdatePassword struct {
@@ -41,8 +133,35 @@ type UserStorageI interface {
 	Create(user *User) (*User, error)
 	Get(id int64) (*User, error)
 	GetAll(params *GetUsersParams) (*GetUsersResult, error)
+	// Export calls fn for every user matching the filters of params in id
+	// order, reading them through a server-side cursor. Limit, Page, Cursor
+	// and Sort are ignored and passwords are left empty.
+	Export(params *GetUsersParams, fn func(user *User) error) error
 	GetByEmail(email string) (*User, error)
-	Update(user *User) (*User, error)
+	// GetByIDs returns the users with the ids or the usernames in one query,
+	// missing users are left out. Usernames match in any case.
+	GetByIDs(ids []int64, usernames []string) ([]*User, error)
+	// TakenUsernames returns the usernames held by a user, deleted users
+	// included, compared case-insensitively and returned in lower case
+	TakenUsernames(usernames []string) ([]string, error)
+	// Search finds the users by the words of the query, the last words may be
+	// incomplete and misspelled words match by similarity
+	Search(params *SearchUsersParams) ([]*UserSearchResult, error)
+	// Update writes the fields of the user, every updatable field if fields
+	// is empty. Empty optional fields are stored as NULL.
+	Update(user *User, fields []string) (*User, error)
 	UpdatePassword(req *UpdatePassword) error
+	// ImportUsers writes the users in one transaction, rolled back with
+	// dryRun. A row the database rejects is reported in its result and does
+	// not stop the others. The ids of the written users are set.
+	ImportUsers(users []*User, onDuplicate string, dryRun bool) ([]*ImportResult, error)
+	// IncrementPopularity raises the score ranking the user in autocomplete
+	IncrementPopularity(id, delta int64) error
 	Delete(id int64) error
+	Restore(id int64) error
+	Purge(id int64) error
+	// PurgeDeleted removes the users deleted before the time
+	PurgeDeleted(before time.Time) (int64, error)
+	// AnonymizeDeleted clears the personal data of the users deleted before the time
+	AnonymizeDeleted(before time.Time) (int64, error)
 }
@@ -9,17 +9,29 @@ import (
 type StorageI interface {
 	User() repo.UserStorageI
 	Permission() repo.PermissionStorageI
+	Notification() repo.NotificationStorageI
+	LoginEvent() repo.LoginEventStorageI
+	Role() repo.RoleStorageI
+	Organization() repo.OrganizationStorageI
 }
 
 type storagePg struct {
-	userRepo       repo.UserStorageI
-	permissionRepo repo.PermissionStorageI
+	userRepo         repo.UserStorageI
+	permissionRepo   repo.PermissionStorageI
+	notificationRepo repo.NotificationStorageI
+	loginEventRepo   repo.LoginEventStorageI
+	roleRepo         repo.RoleStorageI
+	organizationRepo repo.OrganizationStorageI
 }
 
 func NewStoragePg(db *sqlx.DB) StorageI {
 	return &storagePg{
-		userRepo:       postgres.NewUser(db),
-		permissionRepo: postgres.NewPermission(db),
+		userRepo:         postgres.NewUser(db),
+		permissionRepo:   postgres.NewPermission(db),
+		notificationRepo: postgres.NewNotification(db),
+		loginEventRepo:   postgres.NewLoginEvent(db),
+		roleRepo:         postgres.NewRole(db),
+		organizationRepo: postgres.NewOrganization(db),
 	}
 }
 
@@ -30,3 +42,19 @@ func (s *storagePg) User() repo.UserStorageI {
 func (s *storagePg) Permission() repo.PermissionStorageI {
 	return s.permissionRepo
 }
+
+func (s *storagePg) Notification() repo.NotificationStorageI {
+	return s.notificationRepo
+}
+
+func (s *storagePg) LoginEvent() repo.LoginEventStorageI {
+	return s.loginEventRepo
+}
+
+func (s *storagePg) Role() repo.RoleStorageI {
+	return s.roleRepo
+}
+
+func (s *storagePg) Organization() repo.OrganizationStorageI {
+	return s.organizationRepo
+}
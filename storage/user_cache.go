@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+)
+
+const userCachePrefix = "users:cache:"
+
+// UserCache serves GetByIDs from Redis, looking up only the missing users in
+// Postgres. Users written through the cache are dropped from it, the rest
+// expire after the ttl. Passwords are never cached.
+type UserCache struct {
+	repo.UserStorageI
+	inMemory InMemoryStorageI
+	ttl      time.Duration
+	logger   *logrus.Logger
+}
+
+func NewUserCache(userRepo repo.UserStorageI, inMemory InMemoryStorageI, ttl time.Duration, logger *logrus.Logger) *UserCache {
+	return &UserCache{
+		UserStorageI: userRepo,
+		inMemory:     inMemory,
+		ttl:          ttl,
+		logger:       logger,
+	}
+}
+
+func (c *UserCache) GetByIDs(ids []int64, usernames []string) ([]*repo.User, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, userCacheKey(id))
+	}
+
+	values, err := c.inMemory.GetMany(keys)
+	if err != nil {
+		c.logger.WithError(err).Error("failed to read cached users")
+		values = make([]string, len(keys))
+	}
+
+	users := make([]*repo.User, 0, len(ids)+len(usernames))
+	missing := make([]int64, 0)
+
+	for i, value := range values {
+		var user repo.User
+		if value == "" || json.Unmarshal([]byte(value), &user) != nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+
+		users = append(users, &user)
+	}
+
+	if len(missing) == 0 && len(usernames) == 0 {
+		return users, nil
+	}
+
+	found, err := c.UserStorageI.GetByIDs(missing, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make(map[string]string, len(found))
+	for _, user := range found {
+		user.Password = ""
+
+		value, err := json.Marshal(user)
+		if err != nil {
+			return nil, err
+		}
+		cached[userCacheKey(user.ID)] = string(value)
+	}
+
+	if err := c.inMemory.SetMany(cached, c.ttl); err != nil {
+		c.logger.WithError(err).Error("failed to cache users")
+	}
+
+	return append(users, found...), nil
+}
+
+func (c *UserCache) Update(user *repo.User, fields []string) (*repo.User, error) {
+	result, err := c.UserStorageI.Update(user, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	c.drop(user.ID)
+	return result, nil
+}
+
+func (c *UserCache) UpdatePassword(req *repo.UpdatePassword) error {
+	if err := c.UserStorageI.UpdatePassword(req); err != nil {
+		return err
+	}
+
+	c.drop(req.UserID)
+	return nil
+}
+
+func (c *UserCache) ImportUsers(users []*repo.User, onDuplicate string, dryRun bool) ([]*repo.ImportResult, error) {
+	results, err := c.UserStorageI.ImportUsers(users, onDuplicate, dryRun)
+	if err != nil || dryRun {
+		return results, err
+	}
+
+	for n, result := range results {
+		if result.Outcome == repo.ImportUpdated {
+			c.drop(users[n].ID)
+		}
+	}
+
+	return results, nil
+}
+
+func (c *UserCache) IncrementPopularity(id, delta int64) error {
+	if err := c.UserStorageI.IncrementPopularity(id, delta); err != nil {
+		return err
+	}
+
+	c.drop(id)
+	return nil
+}
+
+func (c *UserCache) Delete(id int64) error {
+	if err := c.UserStorageI.Delete(id); err != nil {
+		return err
+	}
+
+	c.drop(id)
+	return nil
+}
+
+func (c *UserCache) Purge(id int64) error {
+	if err := c.UserStorageI.Purge(id); err != nil {
+		return err
+	}
+
+	c.drop(id)
+	return nil
+}
+
+func (c *UserCache) drop(id int64) {
+	if err := c.inMemory.Delete(userCacheKey(id)); err != nil {
+		c.logger.WithError(err).WithField("user_id", id).Error("failed to drop cached user")
+	}
+}
+
+func userCacheKey(id int64) string {
+	return userCachePrefix + strconv.FormatInt(id, 10)
+}
+
+type userCachedStorage struct {
+	StorageI
+	cache *UserCache
+}
+
+// WithUserCache returns strg with its user lookups by ids served by the cache
+func WithUserCache(strg StorageI, cache *UserCache) StorageI {
+	return &userCachedStorage{
+		StorageI: strg,
+		cache:    cache,
+	}
+}
+
+func (s *userCachedStorage) User() repo.UserStorageI {
+	return s.cache
+}
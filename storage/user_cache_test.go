@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type countingUserRepo struct {
+	fakeUserRepo
+	lookups [][]int64
+}
+
+func (f *countingUserRepo) GetByIDs(ids []int64, usernames []string) ([]*repo.User, error) {
+	f.lookups = append(f.lookups, ids)
+
+	users := make([]*repo.User, 0)
+	for _, user := range f.users {
+		for _, id := range ids {
+			if user.ID == id {
+				copied := *user
+				users = append(users, &copied)
+			}
+		}
+		for _, username := range usernames {
+			if user.Username == username {
+				copied := *user
+				users = append(users, &copied)
+			}
+		}
+	}
+	return users, nil
+}
+
+func TestUserCache(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	userRepo := &countingUserRepo{fakeUserRepo: fakeUserRepo{users: map[int64]*repo.User{
+		1: {ID: 1, Username: "alice", Password: "hash"},
+		2: {ID: 2, Username: "bob"},
+	}}}
+	cache := NewUserCache(userRepo, &fakeInMemory{}, time.Minute, logger)
+
+	users, err := cache.GetByIDs([]int64{1, 2, 3}, nil)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	// only the unknown id goes to the database again
+	users, err = cache.GetByIDs([]int64{1, 2, 3}, nil)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, [][]int64{{1, 2, 3}, {3}}, userRepo.lookups)
+
+	for _, user := range users {
+		require.Empty(t, user.Password)
+	}
+
+	_, err = cache.Update(&repo.User{ID: 1, Username: "alicia"}, nil)
+	require.NoError(t, err)
+
+	users, err = cache.GetByIDs([]int64{1}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "alicia", users[0].Username)
+	require.Equal(t, []int64{1}, userRepo.lookups[2])
+}
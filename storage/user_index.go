@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	usersIndex = "autocomplete:users"
+	// usersIndexBuiltKey is set once the index holds every user
+	usersIndexBuiltKey = usersIndex + ":built"
+
+	usersIndexBatch = 500
+)
+
+// UserSuggestion holds the public fields of a user suggested by the index
+type UserSuggestion struct {
+	ID              int64  `json:"id"`
+	Username        string `json:"username"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	ProfileImageUrl string `json:"profile_image_url"`
+}
+
+// UserIndex keeps every prefix of the usernames in Redis sorted sets ranked by
+// popularity, so usernames are completed without a query to Postgres.
+// Users written through the index are indexed again, failures to update the
+// index are logged and fixed by Rebuild.
+type UserIndex struct {
+	repo.UserStorageI
+	inMemory InMemoryStorageI
+	logger   *logrus.Logger
+}
+
+func NewUserIndex(userRepo repo.UserStorageI, inMemory InMemoryStorageI, logger *logrus.Logger) *UserIndex {
+	return &UserIndex{
+		UserStorageI: userRepo,
+		inMemory:     inMemory,
+		logger:       logger,
+	}
+}
+
+// Suggest returns the most popular users whose username starts with the prefix
+func (i *UserIndex) Suggest(prefix string, limit int) ([]*UserSuggestion, error) {
+	documents, err := i.inMemory.GetSuggestions(usersIndex, normalizeUsername(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]*UserSuggestion, 0, len(documents))
+	for _, document := range documents {
+		var suggestion UserSuggestion
+		if err := json.Unmarshal([]byte(document), &suggestion); err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	return suggestions, nil
+}
+
+// Built reports whether the index was built since Redis was emptied
+func (i *UserIndex) Built() (bool, error) {
+	_, err := i.inMemory.Get(usersIndexBuiltKey)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Rebuild indexes every user with a username from scratch and returns their number
+func (i *UserIndex) Rebuild() (int, error) {
+	if err := i.inMemory.ClearSuggestions(usersIndex); err != nil {
+		return 0, err
+	}
+
+	hasUsername := true
+	params := repo.GetUsersParams{
+		Limit:       usersIndexBatch,
+		HasUsername: &hasUsername,
+		SkipCount:   true,
+	}
+
+	count := 0
+	for {
+		result, err := i.UserStorageI.GetAll(&params)
+		if err != nil {
+			return count, err
+		}
+
+		for _, user := range result.Users {
+			if err := i.set(user); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if result.Next == nil {
+			break
+		}
+		params.Cursor = result.Next
+	}
+
+	err := i.inMemory.Set(usersIndexBuiltKey, time.Now().Format(time.RFC3339), 0)
+	return count, err
+}
+
+func (i *UserIndex) Create(user *repo.User) (*repo.User, error) {
+	result, err := i.UserStorageI.Create(user)
+	if err != nil {
+		return nil, err
+	}
+
+	i.index(result)
+	return result, nil
+}
+
+func (i *UserIndex) Update(user *repo.User, fields []string) (*repo.User, error) {
+	result, err := i.UserStorageI.Update(user, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	i.index(result)
+	return result, nil
+}
+
+func (i *UserIndex) ImportUsers(users []*repo.User, onDuplicate string, dryRun bool) ([]*repo.ImportResult, error) {
+	results, err := i.UserStorageI.ImportUsers(users, onDuplicate, dryRun)
+	if err != nil || dryRun {
+		return results, err
+	}
+
+	for n, result := range results {
+		switch result.Outcome {
+		case repo.ImportCreated:
+			i.index(users[n])
+		case repo.ImportUpdated:
+			i.reindex(users[n].ID)
+		}
+	}
+
+	return results, nil
+}
+
+func (i *UserIndex) IncrementPopularity(id, delta int64) error {
+	if err := i.UserStorageI.IncrementPopularity(id, delta); err != nil {
+		return err
+	}
+
+	i.reindex(id)
+	return nil
+}
+
+func (i *UserIndex) Delete(id int64) error {
+	if err := i.UserStorageI.Delete(id); err != nil {
+		return err
+	}
+
+	i.remove(id)
+	return nil
+}
+
+func (i *UserIndex) Restore(id int64) error {
+	if err := i.UserStorageI.Restore(id); err != nil {
+		return err
+	}
+
+	i.reindex(id)
+	return nil
+}
+
+func (i *UserIndex) Purge(id int64) error {
+	if err := i.UserStorageI.Purge(id); err != nil {
+		return err
+	}
+
+	i.remove(id)
+	return nil
+}
+
+func (i *UserIndex) index(user *repo.User) {
+	var err error
+	if user.Username == "" {
+		err = i.inMemory.DeleteSuggestion(usersIndex, strconv.FormatInt(user.ID, 10))
+	} else {
+		err = i.set(user)
+	}
+
+	if err != nil {
+		i.logger.WithError(err).WithField("user_id", user.ID).Error("failed to index user")
+	}
+}
+
+func (i *UserIndex) reindex(id int64) {
+	user, err := i.UserStorageI.Get(id)
+	if err != nil {
+		i.logger.WithError(err).WithField("user_id", id).Error("failed to index user")
+		return
+	}
+
+	i.index(user)
+}
+
+func (i *UserIndex) remove(id int64) {
+	err := i.inMemory.DeleteSuggestion(usersIndex, strconv.FormatInt(id, 10))
+	if err != nil {
+		i.logger.WithError(err).WithField("user_id", id).Error("failed to remove user from index")
+	}
+}
+
+func (i *UserIndex) set(user *repo.User) error {
+	document, err := json.Marshal(&UserSuggestion{
+		ID:              user.ID,
+		Username:        user.Username,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		ProfileImageUrl: user.ProfileImageUrl,
+	})
+	if err != nil {
+		return err
+	}
+
+	// sorted sets are read lowest score first
+	score := -float64(user.Popularity)
+
+	return i.inMemory.SetSuggestion(usersIndex, strconv.FormatInt(user.ID, 10), usernamePrefixes(user.Username), score, string(document))
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// usernamePrefixes returns every prefix of the username, the username included
+func usernamePrefixes(username string) []string {
+	runes := []rune(normalizeUsername(username))
+
+	prefixes := make([]string, 0, len(runes))
+	for n := 1; n <= len(runes); n++ {
+		prefixes = append(prefixes, string(runes[:n]))
+	}
+
+	return prefixes
+}
+
+type indexedStorage struct {
+	StorageI
+	index *UserIndex
+}
+
+// WithUserIndex returns strg with its user writes kept in the index
+func WithUserIndex(strg StorageI, index *UserIndex) StorageI {
+	return &indexedStorage{
+		StorageI: strg,
+		index:    index,
+	}
+}
+
+func (s *indexedStorage) User() repo.UserStorageI {
+	return s.index
+}
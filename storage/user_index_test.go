@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"database/sql"
+	"io"
+	"testing"
+
+	"github.com/ibrat-muslim/blog_app_user_service/storage/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUserRepo struct {
+	repo.UserStorageI
+	users map[int64]*repo.User
+}
+
+func (f *fakeUserRepo) Create(user *repo.User) (*repo.User, error) {
+	user.ID = int64(len(f.users) + 1)
+	f.users[user.ID] = user
+	return user, nil
+}
+
+func (f *fakeUserRepo) Get(id int64) (*repo.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (f *fakeUserRepo) GetAll(params *repo.GetUsersParams) (*repo.GetUsersResult, error) {
+	result := repo.GetUsersResult{Users: make([]*repo.User, 0)}
+	for _, user := range f.users {
+		if params.HasUsername != nil && *params.HasUsername != (user.Username != "") {
+			continue
+		}
+		result.Users = append(result.Users, user)
+	}
+	return &result, nil
+}
+
+func (f *fakeUserRepo) Update(user *repo.User, fields []string) (*repo.User, error) {
+	f.users[user.ID] = user
+	return user, nil
+}
+
+func (f *fakeUserRepo) IncrementPopularity(id, delta int64) error {
+	f.users[id].Popularity += delta
+	return nil
+}
+
+func (f *fakeUserRepo) Delete(id int64) error {
+	delete(f.users, id)
+	return nil
+}
+
+func TestUserIndex(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	userRepo := &fakeUserRepo{users: make(map[int64]*repo.User)}
+	index := NewUserIndex(userRepo, &fakeInMemory{}, logger)
+
+	usernames := func(prefix string) []string {
+		suggestions, err := index.Suggest(prefix, 10)
+		require.NoError(t, err)
+
+		result := make([]string, 0)
+		for _, s := range suggestions {
+			result = append(result, s.Username)
+		}
+		return result
+	}
+
+	alice, err := index.Create(&repo.User{Username: "Alice", FirstName: "Alice", Popularity: 5})
+	require.NoError(t, err)
+	alina, err := index.Create(&repo.User{Username: "alina", Popularity: 1})
+	require.NoError(t, err)
+	_, err = index.Create(&repo.User{FirstName: "No username"})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"Alice", "alina"}, usernames("@al"))
+	require.Equal(t, []string{"alina"}, usernames("ALIN"))
+	require.Empty(t, usernames("b"))
+
+	require.NoError(t, index.IncrementPopularity(alina.ID, 10))
+	require.Equal(t, []string{"alina", "Alice"}, usernames("al"))
+
+	_, err = index.Update(&repo.User{ID: alice.ID, Username: "bob"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alina"}, usernames("al"))
+	require.Equal(t, []string{"bob"}, usernames("b"))
+
+	require.NoError(t, index.Delete(alina.ID))
+	require.Empty(t, usernames("al"))
+
+	count, err := index.Rebuild()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, []string{"bob"}, usernames("b"))
+}